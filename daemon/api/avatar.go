@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+)
+
+const (
+	maxAvatarUpload = 10 * 1024 * 1024
+	maxAvatarSide   = 256
+	// maxAvatarPixels caps the decoded size of an upload, since a small
+	// compressed file can declare enormous dimensions.
+	maxAvatarPixels = 4096 * 4096
+)
+
+// resizeAvatar scales img down so neither side exceeds maxAvatarSide,
+// averaging the source pixels covered by each destination pixel.
+func resizeAvatar(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxAvatarSide && h <= maxAvatarSide {
+		return img
+	}
+
+	dw, dh := maxAvatarSide, maxAvatarSide
+	if w > h {
+		dh = max(1, h*maxAvatarSide/w)
+	} else {
+		dw = max(1, w*maxAvatarSide/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := bounds.Min.Y + y*h/dh
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			x0 := bounds.Min.X + x*w/dw
+			x1 := max(x0+1, bounds.Min.X+(x+1)*w/dw)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+func (s *Server) handleAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAvatarUpload))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.jsonError(w, "Avatar too large", 413)
+			return
+		}
+		s.jsonError(w, "Failed to read avatar", 400)
+		return
+	}
+
+	// Check the dimensions from the header before allocating the image.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		s.jsonError(w, "Invalid image: must be PNG, JPEG or GIF", 400)
+		return
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxAvatarPixels {
+		s.jsonError(w, "Image dimensions too large", 400)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		s.jsonError(w, "Invalid image: must be PNG, JPEG or GIF", 400)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resizeAvatar(img)); err != nil {
+		s.jsonError(w, "Failed to encode avatar", 500)
+		return
+	}
+
+	hash, _, err := s.blobs.Put(&buf)
+	if err != nil {
+		s.jsonError(w, "Failed to store avatar", 500)
+		return
+	}
+
+	profile, err := s.store.GetProfile()
+	if err != nil {
+		s.jsonError(w, "Failed to get profile", 500)
+		return
+	}
+	profile.AvatarHash = hash
+	if err := s.saveProfile(profile); err != nil {
+		s.jsonError(w, "Failed to save profile", 500)
+		return
+	}
+
+	s.jsonResponse(w, profile)
+}
+
+func (s *Server) handleAvatar(w http.ResponseWriter, r *http.Request, peerID string) {
+	if r.Method != "GET" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	var hash string
+	if peerID == s.host.ID().String() {
+		profile, err := s.store.GetProfile()
+		if err != nil {
+			s.jsonError(w, "Failed to get profile", 500)
+			return
+		}
+		hash = profile.AvatarHash
+	} else {
+		profile, err := s.store.GetRemoteProfile(peerID)
+		if err != nil {
+			s.jsonError(w, "Failed to get profile", 500)
+			return
+		}
+		hash = profile.AvatarHash
+	}
+	if hash == "" {
+		s.jsonError(w, "No avatar", 404)
+		return
+	}
+
+	f, err := s.blobs.Open(hash)
+	if err != nil {
+		if err == blobs.ErrNotFound {
+			s.jsonError(w, "Avatar not downloaded yet", 404)
+			return
+		}
+		s.jsonError(w, "Failed to read avatar", 500)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		s.jsonError(w, "Failed to read avatar", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	n, err := node.New(ctx, dir, node.Options{})
+	if err != nil {
+		t.Fatalf("node.New: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	s, err := store.New(filepath.Join(dir, "db"), n.Host.ID().String())
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	b, err := blobs.New(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("blobs.New: %v", err)
+	}
+
+	srv, err := NewServer(n, s, b, nil, nil, nil, events.NewBus(), dir, Options{})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// TestHungEventClientDoesNotStallPosts connects a websocket client that never
+// reads, floods it until its socket buffers and event queue are full, and
+// checks that creating posts still completes promptly and that the client is
+// eventually told it fell behind.
+func TestHungEventClientDoesNotStallPosts(t *testing.T) {
+	srv := newTestServer(t)
+	base := fmt.Sprintf("127.0.0.1:%d", srv.Port())
+
+	hung, _, err := websocket.DefaultDialer.Dial("ws://"+base+"/api/events?token="+srv.token, nil)
+	if err != nil {
+		t.Fatalf("dial events: %v", err)
+	}
+	defer hung.Close()
+
+	// Far more than the kernel will buffer for a client that isn't reading,
+	// plus enough events to overflow its queue.
+	filler := strings.Repeat("x", 64<<10)
+	for range 2 * events.QueueSize {
+		srv.events.Publish("test:filler", filler)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for i := range 20 {
+		req, err := http.NewRequest("POST", "http://"+base+"/api/posts", bytes.NewBufferString(fmt.Sprintf(`{"content":"post %d"}`, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+srv.token)
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("create post %d: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("create post %d: status %d", i, resp.StatusCode)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("create post %d took %v", i, elapsed)
+		}
+	}
+
+	// Once the client reads again it gets what was already queued, then a
+	// close frame saying it fell behind.
+	hung.SetReadDeadline(time.Now().Add(30 * time.Second))
+	for {
+		_, _, err := hung.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+			t.Fatalf("expected close %d, got %v", websocket.CloseTryAgainLater, err)
+		}
+		break
+	}
+}
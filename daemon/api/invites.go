@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+const (
+	defaultInviteTTL  = 24 * time.Hour
+	maxInviteTTL      = 7 * 24 * time.Hour
+	inviteDialTimeout = 30 * time.Second
+)
+
+// Invite is the payload of an invite code. Keys are kept short because the
+// code is meant to be pasted into chats.
+type Invite struct {
+	PeerID    string   `json:"id"`
+	Addrs     []string `json:"addrs"`
+	Secret    string   `json:"secret"`
+	ExpiresAt int64    `json:"exp"`
+	Signature string   `json:"sig"`
+}
+
+func (i *Invite) SigData() []byte {
+	return []byte(fmt.Sprintf("invite|%s|%s|%d|%s", i.PeerID, i.Secret, i.ExpiresAt, strings.Join(i.Addrs, ",")))
+}
+
+func encodeInvite(inv *Invite) (string, error) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeInvite parses an invite code and checks its signature and expiry.
+func decodeInvite(code string) (*Invite, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(code))
+	if err != nil {
+		return nil, fmt.Errorf("malformed invite")
+	}
+	var inv Invite
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("malformed invite")
+	}
+	if inv.PeerID == "" || inv.Secret == "" || inv.Signature == "" {
+		return nil, fmt.Errorf("incomplete invite")
+	}
+	verified, err := node.VerifySignature(inv.PeerID, inv.SigData(), inv.Signature)
+	if err != nil || !verified {
+		return nil, fmt.Errorf("invalid invite signature")
+	}
+	if time.Now().Unix() > inv.ExpiresAt {
+		return nil, fmt.Errorf("invite expired")
+	}
+	return &inv, nil
+}
+
+func (s *Server) handleInvites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	var req struct {
+		TTL string `json:"ttl"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "Invalid request body", 400)
+			return
+		}
+	}
+	ttl := defaultInviteTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			s.jsonError(w, "Invalid ttl", 400)
+			return
+		}
+		ttl = min(d, maxInviteTTL)
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		s.jsonError(w, "Failed to generate invite", 500)
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	inv := &Invite{
+		PeerID:    s.host.ID().String(),
+		Secret:    hex.EncodeToString(secret),
+		ExpiresAt: expiresAt.Unix(),
+	}
+	for _, addr := range s.host.Addrs() {
+		inv.Addrs = append(inv.Addrs, addr.String())
+	}
+	sig, err := s.node.Sign(inv.SigData())
+	if err != nil {
+		s.jsonError(w, "Failed to sign invite", 500)
+		return
+	}
+	inv.Signature = sig
+
+	code, err := encodeInvite(inv)
+	if err != nil {
+		s.jsonError(w, "Failed to encode invite", 500)
+		return
+	}
+	if err := s.store.SaveInvite(inv.Secret, expiresAt); err != nil {
+		s.jsonError(w, "Failed to save invite", 500)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"token":     code,
+		"expiresAt": time.Unix(inv.ExpiresAt, 0),
+	})
+}
+
+func (s *Server) handleRedeemInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "Invalid request body", 400)
+		return
+	}
+	if req.Token == "" {
+		s.jsonError(w, "Token is required", 400)
+		return
+	}
+
+	inv, err := decodeInvite(req.Token)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("Invalid invite: %v", err), 400)
+		return
+	}
+	pid, err := peer.Decode(inv.PeerID)
+	if err != nil {
+		s.jsonError(w, "Invalid peer ID", 400)
+		return
+	}
+	if pid == s.host.ID() {
+		s.jsonError(w, "Cannot redeem your own invite", 400)
+		return
+	}
+	if s.protoHandler == nil {
+		s.jsonError(w, "Protocol handler not available", 500)
+		return
+	}
+
+	switch s.store.GetFriendStatus(inv.PeerID) {
+	case store.FriendApproved:
+		s.jsonResponse(w, map[string]string{"peerId": inv.PeerID, "status": store.FriendApproved})
+		return
+	case store.FriendBlocked:
+		s.jsonError(w, "Peer is blocked", 409)
+		return
+	}
+
+	info := peer.AddrInfo{ID: pid}
+	for _, addr := range inv.Addrs {
+		if ma, err := multiaddr.NewMultiaddr(addr); err == nil {
+			info.Addrs = append(info.Addrs, ma)
+		}
+	}
+
+	known := s.store.IsKnownPeer(inv.PeerID)
+	ctx, cancel := context.WithTimeout(r.Context(), inviteDialTimeout)
+	defer cancel()
+	if err := s.host.Connect(ctx, info); err != nil {
+		s.jsonError(w, fmt.Sprintf("Failed to connect: %v", err), 502)
+		return
+	}
+
+	fmt.Printf("[daemon] Connected to inviting peer: %s\n", inv.PeerID)
+
+	profile, err := s.store.GetRemoteProfile(inv.PeerID)
+	if err != nil {
+		profile = &store.Profile{PeerID: inv.PeerID}
+	}
+	s.store.SaveRemoteProfile(profile)
+	if err := s.store.RecordAddressesSeen(inv.PeerID, inv.Addrs); err != nil {
+		fmt.Printf("Error saving addresses for %s: %v\n", inv.PeerID, err)
+	}
+
+	if !known {
+		s.events.Publish(events.PeerConnected, events.Peer{PeerID: inv.PeerID})
+	}
+
+	// They already asked us, so the invite just settles it.
+	if s.store.GetFriendStatus(inv.PeerID) == store.FriendIncoming {
+		if _, err := s.store.TransitionFriend(inv.PeerID, store.FriendApproved); err != nil {
+			s.friendTransitionError(w, err, "Failed to approve friend")
+			return
+		}
+		s.notifyFriend(pid, s.protoHandler.SendFriendApproved)
+		s.events.Publish(events.FriendApproved, events.Peer{PeerID: inv.PeerID})
+		s.jsonResponse(w, map[string]string{"peerId": inv.PeerID, "status": store.FriendApproved})
+		return
+	}
+
+	previous, err := s.store.TransitionFriend(inv.PeerID, store.FriendOutgoing)
+	if err != nil {
+		s.friendTransitionError(w, err, "Failed to send friend request")
+		return
+	}
+	if err := s.protoHandler.SendFriendRequestWithInvite(ctx, pid, inv.Secret); err != nil {
+		// The inviter never got the request, so don't leave it looking sent.
+		if err := s.store.UndoFriendTransition(inv.PeerID, store.FriendOutgoing, previous); err != nil {
+			fmt.Printf("Error undoing friend request to %s: %v\n", inv.PeerID, err)
+		}
+		s.jsonError(w, fmt.Sprintf("Failed to send friend request: %v", err), 502)
+		return
+	}
+
+	s.events.Publish(events.FriendRequest, events.Peer{PeerID: inv.PeerID})
+
+	s.jsonResponse(w, map[string]string{"peerId": inv.PeerID, "status": store.FriendOutgoing})
+}
@@ -3,105 +3,154 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/events"
 	"github.com/nathanmyles/myfeed/daemon/node"
 	"github.com/nathanmyles/myfeed/daemon/protocols"
+	"github.com/nathanmyles/myfeed/daemon/reconnect"
 	"github.com/nathanmyles/myfeed/daemon/store"
 	syncer "github.com/nathanmyles/myfeed/daemon/sync"
 )
 
 type Server struct {
-	host         host.Host
-	node         *node.Node
-	store        *store.Store
-	syncer       *syncer.Syncer
-	protoHandler *protocols.ProtocolHandler
-	port         int
-	portFile     string
-	server       *http.Server
-	wsClients    map[*websocket.Conn]bool
-	wsMutex      sync.Mutex
-}
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
-func NewServer(n *node.Node, s *store.Store, syn *syncer.Syncer, ph *protocols.ProtocolHandler, dataDir string) (*Server, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create listener: %w", err)
-	}
+	host           host.Host
+	node           *node.Node
+	store          *store.Store
+	blobs          *blobs.Store
+	syncer         *syncer.Syncer
+	protoHandler   *protocols.ProtocolHandler
+	reconnector    *reconnect.Manager
+	port           int
+	portFile       string
+	socketPath     string
+	token          string
+	server         *http.Server
+	socketServer   *http.Server
+	upgrader       websocket.Upgrader
+	allowedOrigins []string
+	events         *events.Bus
+}
+
+const (
+	defaultFeedLimit = 50
+	maxFeedLimit     = 500
+
+	wsWriteTimeout   = 10 * time.Second
+	wsPongTimeout    = 60 * time.Second
+	wsPingInterval   = wsPongTimeout * 9 / 10
+	wsMaxMessageSize = 4096
+)
 
-	port := listener.Addr().(*net.TCPAddr).Port
-	portFile := filepath.Join(dataDir, "daemon.port")
+// Options configures the local API server.
+type Options struct {
+	// Addr is the TCP host:port to listen on. Defaults to a random port on
+	// 127.0.0.1.
+	Addr string
+	// Socket also serves the API on a Unix socket in the data directory.
+	Socket bool
+	// SocketOnly serves the API on the Unix socket alone, with no TCP
+	// listener. It implies Socket.
+	SocketOnly bool
+	// AllowedOrigins lists the browser origins allowed to call the API.
+	// Defaults to DefaultAllowedOrigins.
+	AllowedOrigins []string
+}
 
-	if err := os.WriteFile(portFile, []byte(fmt.Sprintf("%d", port)), 0644); err != nil {
-		listener.Close()
-		return nil, fmt.Errorf("failed to write port file: %w", err)
+func NewServer(n *node.Node, s *store.Store, b *blobs.Store, syn *syncer.Syncer, ph *protocols.ProtocolHandler, rc *reconnect.Manager, bus *events.Bus, dataDir string, opts Options) (*Server, error) {
+	token, err := loadOrCreateToken(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up API token: %w", err)
 	}
 
 	srv := &Server{
-		host:         n.Host,
-		node:         n,
-		store:        s,
-		syncer:       syn,
-		protoHandler: ph,
-		port:         port,
-		portFile:     portFile,
-		wsClients:    make(map[*websocket.Conn]bool),
+		host:           n.Host,
+		node:           n,
+		store:          s,
+		blobs:          b,
+		syncer:         syn,
+		protoHandler:   ph,
+		reconnector:    rc,
+		token:          token,
+		allowedOrigins: opts.AllowedOrigins,
+		events:         bus,
 	}
+	if len(srv.allowedOrigins) == 0 {
+		srv.allowedOrigins = DefaultAllowedOrigins
+	}
+	srv.upgrader = websocket.Upgrader{CheckOrigin: srv.originAllowed}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/status", srv.handleStatus)
 	mux.HandleFunc("/api/feed", srv.handleFeed)
 	mux.HandleFunc("/api/posts", srv.handlePosts)
+	mux.HandleFunc("/api/posts/", srv.handlePost)
 	mux.HandleFunc("/api/peers", srv.handlePeers)
 	mux.HandleFunc("/api/connect", srv.handleConnect)
+	mux.HandleFunc("/api/invites", srv.handleInvites)
+	mux.HandleFunc("/api/invites/redeem", srv.handleRedeemInvite)
 	mux.HandleFunc("/api/profile", srv.handleProfile)
 	mux.HandleFunc("/api/profile/", srv.handleRemoteProfile)
 	mux.HandleFunc("/api/sync", srv.handleSync)
 	mux.HandleFunc("/api/friends", srv.handleFriends)
 	mux.HandleFunc("/api/friends/", srv.handleFriendAction)
+	mux.HandleFunc("/api/blobs", srv.handleBlobs)
+	mux.HandleFunc("/api/blobs/", srv.handleBlob)
 	mux.HandleFunc("/api/events", srv.handleEvents)
+	mux.HandleFunc("/api/events/stream", srv.handleEventStream)
 
-	srv.server = &http.Server{
-		Handler: srv.corsMiddleware(mux),
+	if opts.Socket || opts.SocketOnly {
+		listener, err := listenSocket(filepath.Join(dataDir, SocketFileName))
+		if err != nil {
+			return nil, err
+		}
+		srv.socketPath = listener.Addr().String()
+		// Anyone who can open the socket already has our file permissions, so
+		// it doesn't need the token.
+		srv.socketServer = &http.Server{Handler: mux}
+		go srv.socketServer.Serve(listener)
 	}
 
-	go srv.server.Serve(listener)
+	if !opts.SocketOnly {
+		addr := opts.Addr
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			srv.Close()
+			return nil, fmt.Errorf("failed to create listener: %w", err)
+		}
+		srv.port = listener.Addr().(*net.TCPAddr).Port
+		srv.portFile = filepath.Join(dataDir, "daemon.port")
+		if err := writeFileAtomic(srv.portFile, []byte(strconv.Itoa(srv.port)), 0644); err != nil {
+			listener.Close()
+			srv.Close()
+			return nil, fmt.Errorf("failed to write port file: %w", err)
+		}
+		srv.server = &http.Server{
+			Handler: srv.corsMiddleware(srv.authMiddleware(mux)),
+		}
+		go srv.server.Serve(listener)
+	}
 
 	return srv, nil
 }
 
-func (s *Server) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (s *Server) jsonError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -126,6 +175,8 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		"peerId":         s.host.ID().String(),
 		"addresses":      s.getListeningAddrs(),
 		"connectedPeers": connectedPeers,
+		"bootstrap":      s.node.BootstrapStatus(),
+		"network":        s.node.NetworkStatus(),
 	})
 }
 
@@ -135,17 +186,56 @@ func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := s.store.GetAllPosts()
+	query := r.URL.Query()
+	limit := defaultFeedLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.jsonError(w, "Invalid limit", 400)
+			return
+		}
+		limit = min(n, maxFeedLimit)
+	}
+
+	posts, err := s.store.GetFeed(store.FeedQuery{
+		Limit:  limit,
+		Before: query.Get("before"),
+		After:  query.Get("after"),
+		Author: query.Get("author"),
+	})
 	if err != nil {
+		if err == store.ErrInvalidCursor {
+			s.jsonError(w, "Invalid cursor", 400)
+			return
+		}
 		s.jsonError(w, "Failed to get posts", 500)
 		return
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].CreatedAt.After(posts[j].CreatedAt)
-	})
+	// Posts are newest first: pass X-Next-Cursor as before= for older posts
+	// and X-Prev-Cursor as after= for newer ones.
+	if len(posts) > 0 {
+		w.Header().Set("X-Prev-Cursor", store.FeedCursor(&posts[0]))
+		w.Header().Set("X-Next-Cursor", store.FeedCursor(&posts[len(posts)-1]))
+	}
+
+	items := make([]feedPost, 0, len(posts))
+	for _, post := range posts {
+		counts, mine, err := s.store.GetReactions(post.ID)
+		if err != nil {
+			s.jsonError(w, "Failed to get reactions", 500)
+			return
+		}
+		items = append(items, feedPost{Post: post, Reactions: counts, MyReactions: mine})
+	}
+
+	s.jsonResponse(w, items)
+}
 
-	s.jsonResponse(w, posts)
+type feedPost struct {
+	store.Post
+	Reactions   map[string]int `json:"reactions"`
+	MyReactions []string       `json:"myReactions"`
 }
 
 func (s *Server) handlePosts(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +245,9 @@ func (s *Server) handlePosts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Content string `json:"content"`
+		Content     string         `json:"content"`
+		InReplyTo   *store.PostRef `json:"inReplyTo"`
+		Attachments []string       `json:"attachments"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.jsonError(w, "Invalid request body", 400)
@@ -167,30 +259,315 @@ func (s *Server) handlePosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.InReplyTo != nil {
+		if !store.ValidPostID(req.InReplyTo.PostID) {
+			s.jsonError(w, "inReplyTo.postId must be a post ID", 400)
+			return
+		}
+		if parent, err := s.store.GetPost(req.InReplyTo.PostID); err == nil {
+			req.InReplyTo.AuthorPeerID = parent.AuthorPeerID
+		} else if req.InReplyTo.AuthorPeerID == "" {
+			s.jsonError(w, "Parent post not found", 404)
+			return
+		}
+	}
+
+	for i, hash := range req.Attachments {
+		parsed, err := blobs.ParseHash(hash)
+		if err != nil || !s.blobs.Has(parsed) {
+			s.jsonError(w, fmt.Sprintf("Unknown attachment: %s", hash), 400)
+			return
+		}
+		req.Attachments[i] = parsed
+	}
+
+	// Sign before saving so a friend syncing concurrently never sees the
+	// post unsigned.
 	post := &store.Post{
-		Content: req.Content,
+		ID:          uuid.New().String(),
+		Content:     req.Content,
+		InReplyTo:   req.InReplyTo,
+		Attachments: req.Attachments,
+		CreatedAt:   time.Now(),
+	}
+	signature, err := s.node.Sign(post.SigData())
+	if err != nil {
+		s.jsonError(w, "Failed to sign post", 500)
+		return
 	}
+	post.Signature = signature
 
 	if err := s.store.SavePost(post); err != nil {
 		s.jsonError(w, "Failed to save post", 500)
 		return
 	}
 
-	sigData := fmt.Sprintf("%s|%s|%d", post.ID, post.Content, post.CreatedAt.Unix())
-	signature, err := s.node.Sign([]byte(sigData))
+	s.events.Publish(events.FeedUpdated, nil)
+	s.jsonResponse(w, post)
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	postID := strings.TrimPrefix(r.URL.Path, "/api/posts/")
+	if id, ok := strings.CutSuffix(postID, "/revisions"); ok {
+		s.handlePostRevisions(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(postID, "/thread"); ok {
+		s.handlePostThread(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(postID, "/reactions"); ok {
+		s.handlePostReactions(w, r, id)
+		return
+	}
+	if postID == "" {
+		s.jsonError(w, "Post ID is required", 400)
+		return
+	}
+	if strings.Contains(postID, "/") {
+		s.jsonError(w, "Not found", 404)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		tombstone := &store.Tombstone{
+			PostID:    postID,
+			DeletedAt: time.Now(),
+		}
+		signature, err := s.node.Sign(tombstone.SigData())
+		if err != nil {
+			s.jsonError(w, "Failed to sign tombstone", 500)
+			return
+		}
+		tombstone.Signature = signature
+
+		if err := s.store.DeletePost(tombstone); err != nil {
+			if err == store.ErrPostNotFound {
+				s.jsonError(w, "Post not found", 404)
+				return
+			}
+			s.jsonError(w, "Failed to delete post", 500)
+			return
+		}
+
+		s.events.Publish(events.FeedUpdated, nil)
+		s.jsonResponse(w, map[string]string{"status": "deleted"})
+		return
+	}
+
+	if r.Method == "PATCH" {
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "Invalid request body", 400)
+			return
+		}
+
+		if req.Content == "" {
+			s.jsonError(w, "Content is required", 400)
+			return
+		}
+
+		current, err := s.store.GetPost(postID)
+		if err != nil || current.AuthorPeerID != s.host.ID().String() {
+			s.jsonError(w, "Post not found", 404)
+			return
+		}
+
+		post := *current
+		post.Content = req.Content
+		post.Revision = current.Revision + 1
+		post.EditedAt = time.Now()
+
+		signature, err := s.node.Sign(post.SigData())
+		if err != nil {
+			s.jsonError(w, "Failed to sign post", 500)
+			return
+		}
+		post.Signature = signature
+
+		if err := s.store.EditPost(&post); err != nil {
+			if errors.Is(err, store.ErrStaleRevision) {
+				s.jsonError(w, "Post was edited concurrently", 409)
+				return
+			}
+			s.jsonError(w, "Failed to save post", 500)
+			return
+		}
+
+		s.events.Publish(events.FeedUpdated, nil)
+		s.jsonResponse(w, post)
+		return
+	}
+
+	s.jsonError(w, "Method not allowed", 405)
+}
+
+func (s *Server) handlePostRevisions(w http.ResponseWriter, r *http.Request, postID string) {
+	if r.Method != "GET" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	revisions, err := s.store.GetPostRevisions(postID)
 	if err != nil {
-		s.jsonError(w, "Failed to sign post", 500)
+		if err == store.ErrPostNotFound {
+			s.jsonError(w, "Post not found", 404)
+			return
+		}
+		s.jsonError(w, "Failed to get revisions", 500)
 		return
 	}
-	post.Signature = signature
 
-	if err := s.store.UpdatePostSignature(post.ID, post.Signature); err != nil {
-		s.jsonError(w, "Failed to update signature", 500)
+	s.jsonResponse(w, revisions)
+}
+
+func (s *Server) handlePostThread(w http.ResponseWriter, r *http.Request, postID string) {
+	if r.Method != "GET" {
+		s.jsonError(w, "Method not allowed", 405)
 		return
 	}
 
-	s.BroadcastEvent("feed:updated", nil)
-	s.jsonResponse(w, post)
+	thread, err := s.store.GetThread(postID)
+	if err != nil {
+		if err == store.ErrPostNotFound {
+			s.jsonError(w, "Post not found", 404)
+			return
+		}
+		s.jsonError(w, "Failed to get thread", 500)
+		return
+	}
+
+	s.jsonResponse(w, thread)
+}
+
+func (s *Server) handlePostReactions(w http.ResponseWriter, r *http.Request, postID string) {
+	var emoji string
+	switch r.Method {
+	case "POST":
+		var req struct {
+			Emoji string `json:"emoji"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "Invalid request body", 400)
+			return
+		}
+		emoji = req.Emoji
+	case "DELETE":
+		emoji = r.URL.Query().Get("emoji")
+	default:
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	if !protocols.ValidEmoji(emoji) {
+		s.jsonError(w, "Invalid emoji", 400)
+		return
+	}
+
+	post, err := s.store.GetPost(postID)
+	if err != nil {
+		s.jsonError(w, "Post not found", 404)
+		return
+	}
+
+	reaction := &store.Reaction{
+		PostID:           post.ID,
+		PostAuthorPeerID: post.AuthorPeerID,
+		ReactorPeerID:    s.host.ID().String(),
+		Emoji:            emoji,
+		Removed:          r.Method == "DELETE",
+		CreatedAt:        time.Now(),
+	}
+	signature, err := s.node.Sign(reaction.SigData())
+	if err != nil {
+		s.jsonError(w, "Failed to sign reaction", 500)
+		return
+	}
+	reaction.Signature = signature
+
+	if post.AuthorPeerID != s.host.ID().String() {
+		if s.protoHandler == nil {
+			s.jsonError(w, "Protocol handler not available", 500)
+			return
+		}
+		if err := s.protoHandler.SendReaction(r.Context(), reaction); err != nil {
+			s.jsonError(w, fmt.Sprintf("Failed to deliver reaction: %v", err), 502)
+			return
+		}
+	}
+
+	if err := s.store.SaveReaction(reaction); err != nil {
+		s.jsonError(w, "Failed to save reaction", 500)
+		return
+	}
+
+	counts, mine, err := s.store.GetReactions(post.ID)
+	if err != nil {
+		s.jsonError(w, "Failed to get reactions", 500)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"reactions":   counts,
+		"myReactions": mine,
+	})
+}
+
+func (s *Server) handleBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	hash, size, err := s.blobs.Put(http.MaxBytesReader(w, r.Body, blobs.MaxSize+1))
+	if err != nil {
+		if err == blobs.ErrTooLarge {
+			s.jsonError(w, "Blob too large", 413)
+			return
+		}
+		s.jsonError(w, "Failed to store blob", 500)
+		return
+	}
+
+	s.jsonResponse(w, map[string]interface{}{
+		"hash": hash,
+		"size": size,
+	})
+}
+
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, "/api/blobs/")
+	f, err := s.blobs.Open(hash)
+	if err != nil {
+		if errors.Is(err, blobs.ErrInvalidHash) {
+			s.jsonError(w, "Invalid blob hash", 400)
+			return
+		}
+		if err == blobs.ErrNotFound {
+			s.jsonError(w, "Blob not found", 404)
+			return
+		}
+		s.jsonError(w, "Failed to read blob", 500)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		s.jsonError(w, "Failed to read blob", 500)
+		return
+	}
+
+	// Blobs are immutable, so they can be cached forever.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", info.ModTime(), f)
 }
 
 func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
@@ -202,17 +579,28 @@ func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
 	var peers []map[string]interface{} = []map[string]interface{}{}
 	seenPeers := make(map[peer.ID]bool)
 
+	var states map[string]reconnect.PeerState
+	if s.reconnector != nil {
+		states = s.reconnector.States()
+	}
+	withState := func(entry map[string]interface{}) map[string]interface{} {
+		if state, ok := states[entry["peerId"].(string)]; ok {
+			entry["connection"] = state
+		}
+		return entry
+	}
+
 	for _, conn := range s.host.Network().Conns() {
 		p := conn.RemotePeer()
 		if seenPeers[p] {
 			continue
 		}
 		seenPeers[p] = true
-		peers = append(peers, map[string]interface{}{
+		peers = append(peers, withState(map[string]interface{}{
 			"peerId":  p.String(),
 			"online":  s.host.Network().Connectedness(p) == network.Connected,
 			"address": conn.RemoteMultiaddr().String(),
-		})
+		}))
 	}
 
 	knownPeers := s.store.GetKnownPeers()
@@ -221,10 +609,10 @@ func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
 		if err != nil || seenPeers[p] {
 			continue
 		}
-		peers = append(peers, map[string]interface{}{
+		peers = append(peers, withState(map[string]interface{}{
 			"peerId": peerIDStr,
 			"online": s.host.Network().Connectedness(p) == network.Connected,
-		})
+		}))
 	}
 
 	s.jsonResponse(w, peers)
@@ -255,6 +643,8 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The node reports connections to peers we already know.
+	known := s.store.IsKnownPeer(peerInfo.ID.String())
 	if err := s.host.Connect(r.Context(), *peerInfo); err != nil {
 		s.jsonError(w, fmt.Sprintf("Failed to connect: %v", err), 500)
 		return
@@ -262,13 +652,19 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("[daemon] Connected to new peer: %s\n", peerInfo.ID.String())
 
-	profile := &store.Profile{
-		PeerID:    peerInfo.ID.String(),
-		Addresses: []string{req.Address},
+	profile, err := s.store.GetRemoteProfile(peerInfo.ID.String())
+	if err != nil {
+		profile = &store.Profile{PeerID: peerInfo.ID.String()}
 	}
+	profile.Addresses = []string{req.Address}
 	s.store.SaveRemoteProfile(profile)
+	for _, addr := range peerInfo.Addrs {
+		s.store.RecordDialSuccess(profile.PeerID, addr.String())
+	}
 
-	s.BroadcastEvent("peer:connected", map[string]string{"peerId": peerInfo.ID.String()})
+	if !known {
+		s.events.Publish(events.PeerConnected, events.Peer{PeerID: peerInfo.ID.String()})
+	}
 
 	s.jsonResponse(w, map[string]interface{}{
 		"peerId":  peerInfo.ID.String(),
@@ -299,7 +695,7 @@ func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 		if s.host.Network().Connectedness(peerID) != network.Connected {
 			continue
 		}
-		if _, err := s.syncer.FetchFeed(ctx, peerID, time.Time{}); err != nil {
+		if _, err := s.syncer.FetchFeed(ctx, peerID); err != nil {
 			fmt.Printf("Error syncing feed from %s: %v\n", peerIDStr, err)
 			continue
 		}
@@ -309,6 +705,23 @@ func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, map[string]interface{}{"syncedPeers": synced})
 }
 
+// saveProfile bumps the local profile version and re-signs it so friends can
+// tell the new profile apart from any copy they already hold.
+func (s *Server) saveProfile(profile *store.Profile) error {
+	profile.PeerID = s.host.ID().String()
+	profile.Version++
+	sig, err := s.node.Sign(profile.SigData())
+	if err != nil {
+		return err
+	}
+	profile.Signature = sig
+	if err := s.store.SaveProfile(profile); err != nil {
+		return err
+	}
+	s.events.Publish(events.ProfileUpdated, *profile)
+	return nil
+}
+
 func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		profile, err := s.store.GetProfile()
@@ -316,48 +729,93 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 			s.jsonError(w, "Failed to get profile", 500)
 			return
 		}
-		s.jsonResponse(w, profile)
+		policy, err := s.store.GetAccessPolicy()
+		if err != nil {
+			s.jsonError(w, "Failed to get access policy", 500)
+			return
+		}
+		s.jsonResponse(w, localProfileResponse{Profile: profile, AccessPolicy: policy})
 		return
 	}
 
 	if r.Method == "POST" {
 		var req struct {
-			DisplayName string `json:"displayName"`
-			Bio         string `json:"bio"`
+			DisplayName  *string `json:"displayName"`
+			Bio          *string `json:"bio"`
+			AccessPolicy string  `json:"accessPolicy"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			s.jsonError(w, "Invalid request body", 400)
 			return
 		}
 
+		if req.AccessPolicy != "" && !store.ValidAccessPolicy(req.AccessPolicy) {
+			s.jsonError(w, "Invalid access policy", 400)
+			return
+		}
+
 		profile, err := s.store.GetProfile()
 		if err != nil {
 			s.jsonError(w, "Failed to get profile", 500)
 			return
 		}
 
-		profile.DisplayName = req.DisplayName
-		profile.Bio = req.Bio
+		// Only fields that were sent are changed, so setting just the access
+		// policy leaves the profile and its version alone.
+		if req.DisplayName != nil || req.Bio != nil {
+			if req.DisplayName != nil {
+				profile.DisplayName = *req.DisplayName
+			}
+			if req.Bio != nil {
+				profile.Bio = *req.Bio
+			}
+			if err := s.saveProfile(profile); err != nil {
+				s.jsonError(w, "Failed to save profile", 500)
+				return
+			}
+		}
 
-		if err := s.store.SaveProfile(profile); err != nil {
-			s.jsonError(w, "Failed to save profile", 500)
+		if req.AccessPolicy != "" {
+			if err := s.store.SetAccessPolicy(req.AccessPolicy); err != nil {
+				s.jsonError(w, "Failed to save access policy", 500)
+				return
+			}
+		}
+
+		policy, err := s.store.GetAccessPolicy()
+		if err != nil {
+			s.jsonError(w, "Failed to get access policy", 500)
 			return
 		}
 
-		s.jsonResponse(w, profile)
+		s.jsonResponse(w, localProfileResponse{Profile: profile, AccessPolicy: policy})
 		return
 	}
 
 	s.jsonError(w, "Method not allowed", 405)
 }
 
+type localProfileResponse struct {
+	*store.Profile
+	AccessPolicy string `json:"accessPolicy"`
+}
+
 func (s *Server) handleRemoteProfile(w http.ResponseWriter, r *http.Request) {
+	peerID := r.URL.Path[len("/api/profile/"):]
+	if peerID == "avatar" {
+		s.handleAvatarUpload(w, r)
+		return
+	}
+	if id, ok := strings.CutSuffix(peerID, "/avatar"); ok {
+		s.handleAvatar(w, r, id)
+		return
+	}
+
 	if r.Method != "GET" {
 		s.jsonError(w, "Method not allowed", 405)
 		return
 	}
 
-	peerID := r.URL.Path[len("/api/profile/"):]
 	if peerID == "" {
 		s.jsonError(w, "Peer ID is required", 400)
 		return
@@ -372,29 +830,84 @@ func (s *Server) handleRemoteProfile(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, profile)
 }
 
+// handleEvents streams events over a websocket. The optional topics
+// parameter is a comma-separated list of topics (e.g. post) or event types
+// (e.g. peer:disconnected), and since resumes after a sequence number.
+//
+// Events are queued per client and written by this handler's goroutine, so
+// a stalled client only ever blocks itself. A client whose queue overflows
+// is closed with CloseTryAgainLater and can reconnect with since.
 func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	topics, since, err := eventParams(r)
+	if err != nil {
+		s.jsonError(w, err.Error(), 400)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
+	defer conn.Close()
 
-	s.wsMutex.Lock()
-	s.wsClients[conn] = true
-	s.wsMutex.Unlock()
+	sub := s.events.Subscribe(topics, since)
+	defer sub.Close()
 
-	defer func() {
-		s.wsMutex.Lock()
-		delete(s.wsClients, conn)
-		s.wsMutex.Unlock()
-		conn.Close()
+	// Clients only send pongs and close frames. Reading them keeps the pong
+	// handler running; a client that stops answering pings is dropped.
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				sub.Close()
+				return
+			}
+		}
 	}()
 
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
 	for {
-		_, _, err := conn.ReadMessage()
+		select {
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				if errors.Is(sub.Err(), events.ErrSlowConsumer) {
+					msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "event queue overflowed")
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+				}
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func eventParams(r *http.Request) ([]string, uint64, error) {
+	var topics []string
+	if t := r.URL.Query().Get("topics"); t != "" {
+		topics = strings.Split(t, ",")
+	}
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
 		if err != nil {
-			break
+			return nil, 0, fmt.Errorf("invalid since")
 		}
+		since = n
 	}
+	return topics, since, nil
 }
 
 func (s *Server) handleFriends(w http.ResponseWriter, r *http.Request) {
@@ -409,9 +922,21 @@ func (s *Server) handleFriends(w http.ResponseWriter, r *http.Request) {
 			s.jsonError(w, "Failed to get pending requests", 500)
 			return
 		}
+		outgoing, err := s.store.GetOutgoingRequests()
+		if err != nil {
+			s.jsonError(w, "Failed to get outgoing requests", 500)
+			return
+		}
+		blocked, err := s.store.GetBlockedPeers()
+		if err != nil {
+			s.jsonError(w, "Failed to get blocked peers", 500)
+			return
+		}
 		s.jsonResponse(w, map[string]interface{}{
-			"friends":         friends,
-			"pendingRequests": pending,
+			"friends":          friends,
+			"pendingRequests":  pending,
+			"outgoingRequests": outgoing,
+			"blocked":          blocked,
 		})
 		return
 	}
@@ -428,25 +953,20 @@ func (s *Server) handleFriends(w http.ResponseWriter, r *http.Request) {
 			s.jsonError(w, "Peer ID is required", 400)
 			return
 		}
-
-		friend := &store.Friend{
-			PeerID:    req.PeerID,
-			Status:    "pending",
-			CreatedAt: time.Now(),
-		}
-		if err := s.store.SaveFriend(friend); err != nil {
-			s.jsonError(w, "Failed to send friend request", 500)
+		pid, err := peer.Decode(req.PeerID)
+		if err != nil {
+			s.jsonError(w, "Invalid peer ID", 400)
 			return
 		}
 
-		if s.protoHandler != nil {
-			pid, err := peer.Decode(req.PeerID)
-			if err == nil {
-				s.protoHandler.SendFriendRequest(context.Background(), pid)
-			}
+		if _, err := s.store.TransitionFriend(req.PeerID, store.FriendOutgoing); err != nil {
+			s.friendTransitionError(w, err, "Failed to send friend request")
+			return
 		}
 
-		s.BroadcastEvent("friend:request", map[string]string{"peerId": req.PeerID})
+		s.notifyFriend(pid, s.protoHandler.SendFriendRequest)
+
+		s.events.Publish(events.FriendRequest, events.Peer{PeerID: req.PeerID})
 
 		s.jsonResponse(w, map[string]string{"status": "sent"})
 		return
@@ -461,46 +981,103 @@ func (s *Server) handleFriendAction(w http.ResponseWriter, r *http.Request) {
 		s.jsonError(w, "Peer ID is required", 400)
 		return
 	}
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		s.jsonError(w, "Invalid peer ID", 400)
+		return
+	}
 
 	if r.Method == "POST" {
 		action := r.URL.Query().Get("action")
+		status := s.store.GetFriendStatus(peerID)
 
-		if action == "approve" {
-			friend := &store.Friend{
-				PeerID:    peerID,
-				Status:    "approved",
-				CreatedAt: time.Now(),
+		switch action {
+		case "approve":
+			if status != store.FriendIncoming {
+				s.jsonError(w, "No pending request from peer", 409)
+				return
 			}
-			if err := s.store.SaveFriend(friend); err != nil {
-				s.jsonError(w, "Failed to approve friend", 500)
+			if _, err := s.store.TransitionFriend(peerID, store.FriendApproved); err != nil {
+				s.friendTransitionError(w, err, "Failed to approve friend")
 				return
 			}
 
-			profile := &store.Profile{PeerID: peerID}
-			s.store.SaveRemoteProfile(profile)
+			if profile, err := s.store.GetRemoteProfile(peerID); err == nil {
+				s.store.SaveRemoteProfile(profile)
+			}
 
-			if s.protoHandler != nil {
-				pid, err := peer.Decode(peerID)
-				if err == nil {
-					s.protoHandler.SendFriendApproved(context.Background(), pid)
-				}
+			s.notifyFriend(pid, s.protoHandler.SendFriendApproved)
+			s.events.Publish(events.FriendApproved, events.Peer{PeerID: peerID})
+			s.jsonResponse(w, map[string]string{"status": store.FriendApproved})
+
+		case "reject":
+			if status != store.FriendIncoming {
+				s.jsonError(w, "No pending request from peer", 409)
+				return
 			}
+			if _, err := s.store.TransitionFriend(peerID, store.FriendRejected); err != nil {
+				s.friendTransitionError(w, err, "Failed to reject friend")
+				return
+			}
+			s.notifyFriend(pid, s.protoHandler.SendFriendRejected)
+			s.jsonResponse(w, map[string]string{"status": store.FriendRejected})
 
-			s.BroadcastEvent("friend:approved", map[string]string{"peerId": peerID})
+		case "block":
+			previous, err := s.store.TransitionFriend(peerID, store.FriendBlocked)
+			if err != nil {
+				s.friendTransitionError(w, err, "Failed to block peer")
+				return
+			}
+			switch previous {
+			case store.FriendIncoming:
+				s.notifyFriend(pid, s.protoHandler.SendFriendRejected)
+			case store.FriendOutgoing, store.FriendApproved:
+				s.notifyFriend(pid, s.protoHandler.SendFriendRemoved)
+			}
+			s.jsonResponse(w, map[string]string{"status": store.FriendBlocked})
 
-			s.jsonResponse(w, map[string]string{"status": "approved"})
-			return
-		}
+		case "unblock":
+			if status != store.FriendBlocked {
+				s.jsonError(w, "Peer is not blocked", 409)
+				return
+			}
+			if err := s.store.RemoveFriend(peerID); err != nil {
+				s.friendTransitionError(w, err, "Failed to unblock peer")
+				return
+			}
+			s.jsonResponse(w, map[string]string{"status": "unblocked"})
 
-		s.jsonError(w, "Invalid action", 400)
+		case "cancel":
+			if status != store.FriendOutgoing {
+				s.jsonError(w, "No outgoing request to peer", 409)
+				return
+			}
+			if err := s.store.RemoveFriend(peerID); err != nil {
+				s.friendTransitionError(w, err, "Failed to cancel friend request")
+				return
+			}
+			s.notifyFriend(pid, s.protoHandler.SendFriendRemoved)
+			s.jsonResponse(w, map[string]string{"status": "cancelled"})
+
+		default:
+			s.jsonError(w, "Invalid action", 400)
+		}
 		return
 	}
 
 	if r.Method == "DELETE" {
-		if err := s.store.RemoveFriend(peerID); err != nil {
-			s.jsonError(w, "Failed to remove friend", 500)
+		if s.store.GetFriendStatus(peerID) == store.FriendBlocked {
+			s.jsonError(w, "Peer is blocked, use action=unblock", 409)
+			return
+		}
+		previous, err := s.store.TransitionFriend(peerID, store.FriendNone)
+		if err != nil {
+			s.friendTransitionError(w, err, "Failed to remove friend")
 			return
 		}
+		if previous != store.FriendRejected {
+			s.notifyFriend(pid, s.protoHandler.SendFriendRemoved)
+		}
 		s.jsonResponse(w, map[string]string{"status": "removed"})
 		return
 	}
@@ -508,22 +1085,27 @@ func (s *Server) handleFriendAction(w http.ResponseWriter, r *http.Request) {
 	s.jsonError(w, "Method not allowed", 405)
 }
 
-func (s *Server) BroadcastEvent(eventType string, data interface{}) {
-	msg := map[string]interface{}{
-		"type": eventType,
-		"data": data,
+func (s *Server) friendTransitionError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, store.ErrInvalidFriendTransition) {
+		s.jsonError(w, err.Error(), 409)
+		return
 	}
+	s.jsonError(w, message, 500)
+}
 
-	s.wsMutex.Lock()
-	defer s.wsMutex.Unlock()
-
-	for conn := range s.wsClients {
-		err := conn.WriteJSON(msg)
-		if err != nil {
-			conn.Close()
-			delete(s.wsClients, conn)
-		}
+// notifyFriend sends a friend protocol message in the background so API
+// calls don't block on dialing a peer that may be offline.
+func (s *Server) notifyFriend(pid peer.ID, send func(context.Context, peer.ID) error) {
+	if s.protoHandler == nil {
+		return
 	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := send(ctx, pid); err != nil {
+			fmt.Printf("Error notifying peer %s: %v\n", pid, err)
+		}
+	}()
 }
 
 func (s *Server) getListeningAddrs() []string {
@@ -535,11 +1117,28 @@ func (s *Server) getListeningAddrs() []string {
 	return addrs
 }
 
+// Port returns the TCP port the API listens on, or 0 if it only listens on
+// the Unix socket.
 func (s *Server) Port() int {
 	return s.port
 }
 
+// SocketPath returns the path of the API's Unix socket, if it has one.
+func (s *Server) SocketPath() string {
+	return s.socketPath
+}
+
 func (s *Server) Close() error {
-	os.Remove(s.portFile)
-	return s.server.Close()
+	var err error
+	if s.server != nil {
+		os.Remove(s.portFile)
+		err = s.server.Close()
+	}
+	if s.socketServer != nil {
+		if serr := s.socketServer.Close(); err == nil {
+			err = serr
+		}
+		os.Remove(s.socketPath)
+	}
+	return err
 }
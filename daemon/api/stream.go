@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	streamHeartbeat    = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	streamRetry        = 3 * time.Second
+)
+
+// handleEventStream serves the same events as handleEvents as a
+// text/event-stream. Each event's id is its sequence number, so an
+// EventSource that reconnects resumes through Last-Event-ID on its own.
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.jsonError(w, "Method not allowed", 405)
+		return
+	}
+
+	topics, since, err := eventParams(r)
+	if err != nil {
+		s.jsonError(w, err.Error(), 400)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		since, err = strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			s.jsonError(w, "Invalid Last-Event-ID", 400)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// write sends one frame, giving up on a client that stops reading.
+	write := func(frame string) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprint(w, frame); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !write(fmt.Sprintf("retry: %d\n\n", streamRetry.Milliseconds())) {
+		return
+	}
+
+	sub := s.events.Subscribe(topics, since)
+	defer sub.Close()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				fmt.Printf("Error encoding event %d: %v\n", e.Seq, err)
+				continue
+			}
+			if !write(fmt.Sprintf("id: %d\ndata: %s\n\n", e.Seq, data)) {
+				return
+			}
+		}
+	}
+}
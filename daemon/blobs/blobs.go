@@ -0,0 +1,208 @@
+package blobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// ChunkSize is the unit blobs are transferred and verified in.
+	ChunkSize = 256 * 1024
+	// MaxSize bounds uploads and downloads so a peer can't fill our disk
+	// with a single attachment.
+	MaxSize = 50 * 1024 * 1024
+)
+
+var (
+	ErrNotFound     = errors.New("blob not found")
+	ErrInvalidHash  = errors.New("invalid blob hash")
+	ErrTooLarge     = errors.New("blob too large")
+	ErrHashMismatch = errors.New("blob content does not match its hash")
+)
+
+// Store keeps blobs on disk keyed by CIDv1 (raw, sha2-256). Downloads in
+// progress live under partial/ so they can be resumed.
+type Store struct {
+	dir string
+}
+
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "partial"), 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// ParseHash checks that hash is a CID this store can verify and returns its
+// canonical string form.
+func ParseHash(hash string) (string, error) {
+	c, err := cid.Decode(hash)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	if c.Prefix().MhType != mh.SHA2_256 {
+		return "", fmt.Errorf("%w: unsupported hash function", ErrInvalidHash)
+	}
+	return c.String(), nil
+}
+
+func hashFromDigest(h hash.Hash) (string, error) {
+	digest, err := mh.Encode(h.Sum(nil), mh.SHA2_256)
+	if err != nil {
+		return "", err
+	}
+	return cid.NewCidV1(cid.Raw, digest).String(), nil
+}
+
+// ChunkHash returns the hex sha256 of one chunk, used to verify chunks as
+// they arrive before the whole blob can be checked against its CID.
+func ChunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+func (s *Store) PartialPath(hash string) string {
+	return filepath.Join(s.dir, "partial", hash)
+}
+
+func (s *Store) Has(hash string) bool {
+	hash, err := ParseHash(hash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(s.path(hash))
+	return err == nil
+}
+
+func (s *Store) Open(hash string) (*os.File, error) {
+	hash, err := ParseHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Put stores the content of r and returns its hash.
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "partial"), "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return "", 0, err
+	}
+	if size > MaxSize {
+		return "", 0, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	hash, err := hashFromDigest(h)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(hash)); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// Commit verifies a completed partial download against its hash and moves
+// it into the store.
+func (s *Store) Commit(hash string) error {
+	hash, err := ParseHash(hash)
+	if err != nil {
+		return err
+	}
+	partial := s.PartialPath(hash)
+	f, err := os.Open(partial)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	actual, err := hashFromDigest(h)
+	if err != nil {
+		return err
+	}
+	if actual != hash {
+		os.Remove(partial)
+		return ErrHashMismatch
+	}
+	return os.Rename(partial, s.path(hash))
+}
+
+// Manifest describes a blob as a list of chunk hashes.
+type Manifest struct {
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+func (s *Store) Manifest(hash string) (*Manifest, error) {
+	f, err := s.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	manifest := &Manifest{}
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			manifest.Chunks = append(manifest.Chunks, ChunkHash(buf[:n]))
+			manifest.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// ReadChunk returns chunk index of a stored blob.
+func (s *Store) ReadChunk(hash string, index int) ([]byte, error) {
+	f, err := s.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, ChunkSize)
+	n, err := f.ReadAt(buf, int64(index)*ChunkSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const FileName = "config.json"
+
+// Config holds the optional settings read from config.json in the data
+// directory. Command-line flags override anything set here.
+type Config struct {
+	BootstrapPeers    []string `json:"bootstrapPeers,omitempty"`
+	DHTProtocolPrefix string   `json:"dhtProtocolPrefix,omitempty"`
+	ConnLowWater      int      `json:"connLowWater,omitempty"`
+	ConnHighWater     int      `json:"connHighWater,omitempty"`
+	APIAddr           string   `json:"apiAddr,omitempty"`
+	APISocket         bool     `json:"apiSocket,omitempty"`
+	APISocketOnly     bool     `json:"apiSocketOnly,omitempty"`
+	APIAllowedOrigins []string `json:"apiAllowedOrigins,omitempty"`
+}
+
+// Load reads config.json from dataDir. A missing file yields an empty config.
+func Load(dataDir string) (*Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(filepath.Join(dataDir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", FileName, err)
+	}
+	return &cfg, nil
+}
+
+// BootstrapAddrInfos parses the bootstrap multiaddrs, merging addresses that
+// belong to the same peer.
+func (c *Config) BootstrapAddrInfos() ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	index := make(map[peer.ID]int)
+	for _, addr := range c.BootstrapPeers {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+		}
+		if i, ok := index[info.ID]; ok {
+			infos[i].Addrs = append(infos[i].Addrs, info.Addrs...)
+			continue
+		}
+		index[info.ID] = len(infos)
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,193 @@
+package events
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types. The part before the colon is the event's topic.
+const (
+	PostReceived        = "post:received"
+	ReplyReceived       = "reply:received"
+	ReactionReceived    = "reaction:received"
+	FeedUpdated         = "feed:updated"
+	PeerConnected       = "peer:connected"
+	PeerDisconnected    = "peer:disconnected"
+	ProfileUpdated      = "profile:updated"
+	SyncCompleted       = "sync:completed"
+	FriendRequest       = "friend:request"
+	FriendApproved      = "friend:approved"
+	FriendRejected      = "friend:rejected"
+	FriendRemoved       = "friend:removed"
+	NetworkReachability = "network:reachability"
+)
+
+const (
+	// HistorySize is how many recent events the bus keeps for clients
+	// resuming from a sequence number.
+	HistorySize = 1024
+	// QueueSize is how many live events a subscriber may fall behind by
+	// before it is dropped.
+	QueueSize = 256
+)
+
+// ErrSlowConsumer is reported by a subscription that was dropped because its
+// queue filled up.
+var ErrSlowConsumer = errors.New("subscriber fell too far behind")
+
+// Event is one published event. Seq increases by one with every event
+// published since the daemon started.
+type Event struct {
+	Seq  uint64      `json:"seq"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Peer is the payload of peer and friend events.
+type Peer struct {
+	PeerID string `json:"peerId"`
+}
+
+// Sync is the payload of sync:completed.
+type Sync struct {
+	PeerID   string `json:"peerId"`
+	NewPosts int    `json:"newPosts"`
+}
+
+// Topic returns the topic of an event type, e.g. "post" for post:received.
+func Topic(eventType string) string {
+	topic, _, _ := strings.Cut(eventType, ":")
+	return topic
+}
+
+// Bus fans events out to subscribers without ever blocking the publisher.
+// A subscriber whose queue is full is dropped rather than waited for.
+type Bus struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish assigns the event the next sequence number and queues it for every
+// matching subscriber. It is safe to call from any goroutine and on a nil Bus.
+func (b *Bus) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Event{Seq: b.seq, Type: eventType, Time: time.Now(), Data: data}
+	if len(b.history) == HistorySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:HistorySize-1]
+	}
+	b.history = append(b.history, e)
+
+	for sub := range b.subs {
+		if !sub.matches(eventType) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.dropLocked(sub, ErrSlowConsumer)
+		}
+	}
+}
+
+// Seq returns the sequence number of the latest event.
+func (b *Bus) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Subscribe returns a subscription to the given topics or event types; no
+// topics means everything. With a non-zero since, retained events after it
+// are queued first, so a client that remembers the last sequence number it
+// saw misses nothing still in the history. A since ahead of the bus means the
+// daemon restarted, so the whole history is replayed.
+func (b *Bus) Subscribe(topics []string, since uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{bus: b, topics: topics}
+	if since > b.seq {
+		since = 0
+		if len(b.history) > 0 {
+			since = b.history[0].Seq - 1
+		}
+	} else if since == 0 {
+		since = b.seq
+	}
+
+	var replay []Event
+	for _, e := range b.history {
+		if e.Seq > since && sub.matches(e.Type) {
+			replay = append(replay, e)
+		}
+	}
+
+	sub.ch = make(chan Event, QueueSize+len(replay))
+	for _, e := range replay {
+		sub.ch <- e
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *Bus) dropLocked(sub *Subscription, err error) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.ch)
+}
+
+// Subscription is one subscriber's queue of events.
+type Subscription struct {
+	bus    *Bus
+	topics []string
+	ch     chan Event
+	err    error
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription is closed or dropped.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err reports why the subscription ended, once Events is closed. It is nil
+// if the subscription was closed by its owner.
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.dropLocked(s, nil)
+}
+
+func (s *Subscription) matches(eventType string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	return slices.Contains(s.topics, eventType) || slices.Contains(s.topics, Topic(eventType))
+}
@@ -6,20 +6,32 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/nathanmyles/myfeed/daemon/api"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/config"
+	"github.com/nathanmyles/myfeed/daemon/events"
 	"github.com/nathanmyles/myfeed/daemon/node"
 	"github.com/nathanmyles/myfeed/daemon/protocols"
+	"github.com/nathanmyles/myfeed/daemon/reconnect"
 	"github.com/nathanmyles/myfeed/daemon/store"
 	"github.com/nathanmyles/myfeed/daemon/sync"
 )
 
 func main() {
 	dataDir := flag.String("data", "", "Data directory for the daemon")
+	bootstrap := flag.String("bootstrap", "", "Comma-separated bootstrap peer multiaddrs (overrides config)")
+	dhtPrefix := flag.String("dht-prefix", "", "DHT protocol prefix for a private overlay, e.g. /myfeed (overrides config)")
+	connLow := flag.Int("conn-low", 0, "Connection manager low watermark (overrides config)")
+	connHigh := flag.Int("conn-high", 0, "Connection manager high watermark (overrides config)")
+	apiAddr := flag.String("api-addr", "", "Fixed host:port for the API, e.g. 0.0.0.0:8080 (overrides config)")
+	apiSocket := flag.Bool("api-socket", false, "Also serve the API on a Unix socket in the data directory")
+	apiSocketOnly := flag.Bool("api-socket-only", false, "Serve the API only on the Unix socket, with no TCP listener")
+	apiOrigins := flag.String("api-origins", "", "Comma-separated browser origins allowed to call the API (overrides config)")
 	flag.Parse()
 
 	if *dataDir == "" {
@@ -36,16 +48,59 @@ func main() {
 		os.Exit(1)
 	}
 
+	cfg, err := config.Load(*dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	if *bootstrap != "" {
+		cfg.BootstrapPeers = strings.Split(*bootstrap, ",")
+	}
+	if *dhtPrefix != "" {
+		cfg.DHTProtocolPrefix = *dhtPrefix
+	}
+	if *connLow > 0 {
+		cfg.ConnLowWater = *connLow
+	}
+	if *connHigh > 0 {
+		cfg.ConnHighWater = *connHigh
+	}
+	if *apiAddr != "" {
+		cfg.APIAddr = *apiAddr
+	}
+	if *apiSocket {
+		cfg.APISocket = true
+	}
+	if *apiSocketOnly {
+		cfg.APISocketOnly = true
+	}
+	if *apiOrigins != "" {
+		cfg.APIAllowedOrigins = strings.Split(*apiOrigins, ",")
+	}
+	bootstrapPeers, err := cfg.BootstrapAddrInfos()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse bootstrap peers: %v\n", err)
+		os.Exit(1)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	node, err := node.New(ctx, *dataDir)
+	node, err := node.New(ctx, *dataDir, node.Options{
+		BootstrapPeers:    bootstrapPeers,
+		DHTProtocolPrefix: cfg.DHTProtocolPrefix,
+		ConnLowWater:      cfg.ConnLowWater,
+		ConnHighWater:     cfg.ConnHighWater,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create node: %v\n", err)
 		os.Exit(1)
 	}
 	defer node.Close()
 
+	bus := events.NewBus()
+	node.SetEventBus(bus)
+
 	fmt.Printf("Node started with peer ID: %s\n", node.Host.ID())
 	fmt.Println("Listening on:")
 	for _, addr := range node.GetListeningAddrs() {
@@ -61,29 +116,69 @@ func main() {
 	defer store.Close()
 
 	node.FriendChecker.SetStore(store)
+	if err := node.TrackAddresses(store); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to track peer addresses: %v\n", err)
+		os.Exit(1)
+	}
+	go node.ProtectFriends(ctx)
+
+	blobStore, err := blobs.New(*dataDir + "/blobs")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create blob store: %v\n", err)
+		os.Exit(1)
+	}
 
-	protoHandler := protocols.NewProtocolHandler(node.Host, store)
+	protoHandler := protocols.NewProtocolHandler(node, store, blobStore)
+	protoHandler.SetEventBus(bus)
 	protoHandler.Register()
 
 	protoHandler.SetFriendApprovedCallback(func(peerID string) {
 		fmt.Printf("Friend approved received from: %s\n", peerID)
 	})
 
-	syncer := sync.NewSyncer(node.Host, store)
+	protoHandler.SetFriendRejectedCallback(func(peerID string) {
+		fmt.Printf("Friend request rejected by: %s\n", peerID)
+	})
+
+	protoHandler.SetFriendRemovedCallback(func(peerID string) {
+		fmt.Printf("Friend removed by: %s\n", peerID)
+	})
+
+	syncer := sync.NewSyncer(node.Host, store, blobStore)
+	syncer.SetEventBus(bus)
 	syncWorker := sync.NewSyncWorker(syncer, store, node.Host, 30*time.Second)
 
-	server, err := api.NewServer(node, store, syncer, protoHandler, *dataDir)
+	reconnector := reconnect.NewManager(node, store)
+	reconnector.SetConnectedCallback(func(p peer.ID) {
+		if _, err := syncer.FetchFeed(ctx, p); err != nil {
+			fmt.Printf("Error syncing feed from %s: %v\n", p, err)
+		}
+	})
+
+	server, err := api.NewServer(node, store, blobStore, syncer, protoHandler, reconnector, bus, *dataDir, api.Options{
+		Addr:           cfg.APIAddr,
+		Socket:         cfg.APISocket,
+		SocketOnly:     cfg.APISocketOnly,
+		AllowedOrigins: cfg.APIAllowedOrigins,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create API server: %v\n", err)
 		os.Exit(1)
 	}
 	defer server.Close()
 
-	fmt.Printf("API server listening on port %d\n", server.Port())
+	if server.Port() != 0 {
+		fmt.Printf("API server listening on port %d\n", server.Port())
+	}
+	if server.SocketPath() != "" {
+		fmt.Printf("API server listening on %s\n", server.SocketPath())
+	}
 
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
+		discoverTicker := time.NewTicker(time.Minute)
+		defer discoverTicker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
@@ -92,13 +187,20 @@ func main() {
 				if err := node.Advertise(ctx); err != nil {
 					fmt.Printf("Error advertising: %v\n", err)
 				}
+			case <-discoverTicker.C:
+				connectToDiscoveredPeers(ctx, node)
 			}
 		}
 	}()
 
 	go syncWorker.Start(ctx)
 
-	go connectToKnownPeers(ctx, node, store, syncer)
+	go func() {
+		if err := node.Bootstrap(ctx); err != nil {
+			fmt.Printf("Error bootstrapping: %v\n", err)
+		}
+		reconnector.Start(ctx)
+	}()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -108,33 +210,19 @@ func main() {
 	cancel()
 }
 
-func connectToKnownPeers(ctx context.Context, n *node.Node, s *store.Store, syncer *sync.Syncer) {
-	profiles := s.GetKnownPeersWithProfiles()
-
-	for _, profile := range profiles {
-		if len(profile.Addresses) == 0 {
+func connectToDiscoveredPeers(ctx context.Context, n *node.Node) {
+	peers, err := n.DiscoverPeers(ctx)
+	if err != nil {
+		fmt.Printf("Error discovering peers: %v\n", err)
+		return
+	}
+	for _, pi := range peers {
+		if n.IsConnected(pi.ID) {
 			continue
 		}
-		for _, addr := range profile.Addresses {
-			peerInfo, err := peer.AddrInfoFromString(addr)
-			if err != nil {
-				continue
-			}
-			if n.Host.Network().Connectedness(peerInfo.ID) == network.Connected {
-				continue
-			}
-			fmt.Printf("Connecting to known peer: %s\n", profile.PeerID)
-			if err := n.Host.Connect(ctx, *peerInfo); err != nil {
-				fmt.Printf("Failed to connect to %s: %v\n", profile.PeerID, err)
-				continue
-			}
-			fmt.Printf("Connected to known peer: %s\n", profile.PeerID)
-
-			if syncer != nil {
-				pid, _ := peer.Decode(profile.PeerID)
-				syncer.FetchFeed(ctx, pid, time.Time{})
-			}
-			break
+		if err := n.Host.Connect(ctx, pi); err != nil {
+			continue
 		}
+		fmt.Printf("Connected to discovered peer: %s\n", pi.ID)
 	}
 }
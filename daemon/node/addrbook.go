@@ -0,0 +1,60 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+type AddressBook interface {
+	IsKnownPeer(peerID string) bool
+	RecordAddressesSeen(peerID string, addrs []string) error
+	RecordDialSuccess(peerID, addr string) error
+}
+
+// TrackAddresses keeps book up to date with the address each outbound
+// connection succeeded on and the listen addresses peers report via identify.
+// Only peers the book already knows are recorded, so DHT traffic doesn't fill it.
+func (n *Node) TrackAddresses(book AddressBook) error {
+	sub, err := n.Host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to identify events: %w", err)
+	}
+	n.addrSub = sub
+
+	n.Host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			// Inbound connections come from an ephemeral port we can't dial back.
+			if conn.Stat().Direction != network.DirOutbound {
+				return
+			}
+			peerID, addr := conn.RemotePeer().String(), conn.RemoteMultiaddr().String()
+			go func() {
+				if !book.IsKnownPeer(peerID) {
+					return
+				}
+				if err := book.RecordDialSuccess(peerID, addr); err != nil {
+					fmt.Printf("Error recording address for %s: %v\n", peerID, err)
+				}
+			}()
+		},
+	})
+
+	go func() {
+		for e := range sub.Out() {
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			if !book.IsKnownPeer(evt.Peer.String()) {
+				continue
+			}
+			var addrs []string
+			for _, addr := range evt.ListenAddrs {
+				addrs = append(addrs, addr.String())
+			}
+			if err := book.RecordAddressesSeen(evt.Peer.String(), addrs); err != nil {
+				fmt.Printf("Error recording addresses for %s: %v\n", evt.Peer, err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,147 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+const (
+	DefaultConnLowWater  = 100
+	DefaultConnHighWater = 200
+
+	connGracePeriod         = time.Minute
+	friendTag               = "myfeed-friend"
+	friendTagValue          = 100
+	friendProtectionRefresh = 30 * time.Second
+)
+
+// strangerLimits is the per-peer budget for anyone who isn't an approved
+// friend: enough for DHT, identify and a profile fetch, not for bulk transfers.
+var strangerLimits = rcmgr.ResourceLimits{
+	Streams:         64,
+	StreamsInbound:  32,
+	StreamsOutbound: 32,
+	Conns:           4,
+	ConnsInbound:    2,
+	ConnsOutbound:   2,
+	FD:              2,
+	Memory:          16 << 20,
+}
+
+// friendLimits is the per-peer budget for approved friends, sized for feed
+// syncs and chunked blob transfers running side by side.
+var friendLimits = rcmgr.BaseLimit{
+	Streams:         1024,
+	StreamsInbound:  512,
+	StreamsOutbound: 512,
+	Conns:           16,
+	ConnsInbound:    8,
+	ConnsOutbound:   8,
+	FD:              8,
+	Memory:          256 << 20,
+}
+
+// friendLimiter hands out friendLimits to approved friends and the stranger
+// defaults to everyone else. Limits are picked when a peer's scope is created,
+// so a newly approved friend gets the larger budget on its next connection.
+type friendLimiter struct {
+	rcmgr.Limiter
+	friends *FriendChecker
+}
+
+func (l *friendLimiter) GetPeerLimits(p peer.ID) rcmgr.Limit {
+	if l.friends.isApproved(p) {
+		return friendLimits
+	}
+	return l.Limiter.GetPeerLimits(p)
+}
+
+func newResourceManager(friends *FriendChecker) (network.ResourceManager, error) {
+	limits := rcmgr.PartialLimitConfig{
+		PeerDefault: strangerLimits,
+	}.Build(rcmgr.DefaultLimits.AutoScale())
+
+	limiter := &friendLimiter{Limiter: rcmgr.NewFixedLimiter(limits), friends: friends}
+	return rcmgr.NewResourceManager(limiter)
+}
+
+// ProtectFriends keeps approved friends tagged and protected in the
+// connection manager so DHT and mDNS peers can't crowd them out. It runs
+// until ctx is done.
+func (n *Node) ProtectFriends(ctx context.Context) {
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			p := conn.RemotePeer()
+			if n.FriendChecker.isApproved(p) {
+				n.protect(p)
+			}
+		},
+	}
+	n.Host.Network().Notify(notifee)
+	defer n.Host.Network().StopNotify(notifee)
+
+	n.refreshProtection()
+	ticker := time.NewTicker(friendProtectionRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.refreshProtection()
+		}
+	}
+}
+
+func (n *Node) protect(p peer.ID) {
+	n.protectMu.Lock()
+	n.protected[p] = true
+	n.protectMu.Unlock()
+
+	cm := n.Host.ConnManager()
+	cm.TagPeer(p, friendTag, friendTagValue)
+	cm.Protect(p, friendTag)
+}
+
+// refreshProtection protects every approved friend and drops protection from
+// peers that are no longer friends.
+func (n *Node) refreshProtection() {
+	n.FriendChecker.mu.RLock()
+	s := n.FriendChecker.store
+	n.FriendChecker.mu.RUnlock()
+	if s == nil {
+		return
+	}
+
+	friends, err := s.GetFriends()
+	if err != nil {
+		fmt.Printf("Error listing friends to protect: %v\n", err)
+		return
+	}
+
+	current := make(map[peer.ID]bool)
+	for _, friend := range friends {
+		p, err := peer.Decode(friend.PeerID)
+		if err != nil {
+			continue
+		}
+		current[p] = true
+		n.protect(p)
+	}
+
+	cm := n.Host.ConnManager()
+	n.protectMu.Lock()
+	defer n.protectMu.Unlock()
+	for p := range n.protected {
+		if !current[p] {
+			cm.Unprotect(p, friendTag)
+			cm.UntagPeer(p, friendTag)
+			delete(n.protected, p)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/nathanmyles/myfeed/daemon/events"
+)
+
+// NetworkStatus describes how other peers can reach this node.
+type NetworkStatus struct {
+	Reachability string   `json:"reachability"`
+	PublicAddrs  []string `json:"publicAddrs"`
+	RelayAddrs   []string `json:"relayAddrs"`
+	UsingRelay   bool     `json:"usingRelay"`
+}
+
+// SetEventBus makes the node publish reachability changes, and connects and
+// disconnects of peers the store knows, to bus.
+func (n *Node) SetEventBus(bus *events.Bus) {
+	n.netMu.Lock()
+	defer n.netMu.Unlock()
+	n.events = bus
+}
+
+func (n *Node) Reachability() network.Reachability {
+	n.netMu.RLock()
+	defer n.netMu.RUnlock()
+	return n.reachability
+}
+
+func (n *Node) NetworkStatus() NetworkStatus {
+	n.netMu.RLock()
+	defer n.netMu.RUnlock()
+	return n.networkStatusLocked()
+}
+
+func (n *Node) networkStatusLocked() NetworkStatus {
+	return NetworkStatus{
+		Reachability: strings.ToLower(n.reachability.String()),
+		PublicAddrs:  append([]string{}, n.publicAddrs...),
+		RelayAddrs:   append([]string{}, n.relayAddrs...),
+		UsingRelay:   len(n.relayAddrs) > 0,
+	}
+}
+
+// watchNetwork follows reachability and address changes reported by the
+// AutoNAT and identify services, and peers connecting and disconnecting,
+// until ctx is done or the node is closed.
+func (n *Node) watchNetwork(ctx context.Context) error {
+	sub, err := n.Host.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+		new(event.EvtPeerConnectednessChanged),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to network events: %w", err)
+	}
+	n.netSub = sub
+
+	n.updateAddrs(n.Host.Addrs())
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch evt := e.(type) {
+				case event.EvtLocalReachabilityChanged:
+					n.updateReachability(evt.Reachability)
+				case event.EvtLocalAddressesUpdated:
+					addrs := make([]multiaddr.Multiaddr, 0, len(evt.Current))
+					for _, a := range evt.Current {
+						addrs = append(addrs, a.Address)
+					}
+					n.updateAddrs(addrs)
+				case event.EvtPeerConnectednessChanged:
+					n.publishConnectedness(evt)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (n *Node) updateReachability(r network.Reachability) {
+	n.netMu.Lock()
+	if n.reachability == r {
+		n.netMu.Unlock()
+		return
+	}
+	n.reachability = r
+	n.notifyNetworkLocked()
+}
+
+func (n *Node) updateAddrs(addrs []multiaddr.Multiaddr) {
+	var public, relayed []string
+	for _, addr := range addrs {
+		full := fmt.Sprintf("%s/p2p/%s", addr, n.Host.ID())
+		if isCircuitAddr(addr) {
+			relayed = append(relayed, full)
+		} else if manet.IsPublicAddr(addr) {
+			public = append(public, full)
+		}
+	}
+
+	n.netMu.Lock()
+	if slices.Equal(n.publicAddrs, public) && slices.Equal(n.relayAddrs, relayed) {
+		n.netMu.Unlock()
+		return
+	}
+	n.publicAddrs = public
+	n.relayAddrs = relayed
+	n.notifyNetworkLocked()
+}
+
+// notifyNetworkLocked releases netMu before publishing the new status.
+func (n *Node) notifyNetworkLocked() {
+	bus := n.events
+	status := n.networkStatusLocked()
+	n.netMu.Unlock()
+	bus.Publish(events.NetworkReachability, status)
+}
+
+// publishConnectedness reports known peers connecting and disconnecting.
+// Everyone else is DHT and mDNS traffic the UI doesn't care about.
+func (n *Node) publishConnectedness(evt event.EvtPeerConnectednessChanged) {
+	if !n.FriendChecker.isKnown(evt.Peer) {
+		return
+	}
+	n.netMu.RLock()
+	bus := n.events
+	n.netMu.RUnlock()
+
+	payload := events.Peer{PeerID: evt.Peer.String()}
+	switch evt.Connectedness {
+	case network.Connected:
+		bus.Publish(events.PeerConnected, payload)
+	case network.NotConnected:
+		bus.Publish(events.PeerDisconnected, payload)
+	}
+}
+
+func isCircuitAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
@@ -7,43 +7,152 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/store"
 )
 
+type FriendStore interface {
+	IsFriend(peerID string) bool
+	IsKnownPeer(peerID string) bool
+	GetFriends() ([]store.Friend, error)
+}
+
 type FriendChecker struct {
-	store interface {
-		IsFriend(peerID string) bool
-	}
+	mu    sync.RWMutex
+	store FriendStore
+	host  host.Host
 }
 
-func (f *FriendChecker) SetStore(store interface{ IsFriend(peerID string) bool }) {
+func (f *FriendChecker) SetStore(store FriendStore) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.store = store
 }
 
-func (f *FriendChecker) AllowReserve(p peer.ID, a multiaddr.Multiaddr) bool {
+func (f *FriendChecker) setHost(h host.Host) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.host = h
+}
+
+func (f *FriendChecker) isFriend(p peer.ID) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.store == nil || f.store.IsFriend(p.String())
 }
 
+// isApproved is like isFriend but treats everyone as a stranger until the
+// store is set.
+func (f *FriendChecker) isApproved(p peer.ID) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.store != nil && f.store.IsFriend(p.String())
+}
+
+func (f *FriendChecker) isKnown(p peer.ID) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.store != nil && f.store.IsKnownPeer(p.String())
+}
+
+func (f *FriendChecker) AllowReserve(p peer.ID, a multiaddr.Multiaddr) bool {
+	return f.isFriend(p)
+}
+
 func (f *FriendChecker) AllowConnect(src peer.ID, srcAddr multiaddr.Multiaddr, dest peer.ID) bool {
-	return f.store == nil || f.store.IsFriend(src.String())
+	return f.isFriend(src)
+}
+
+// RelayCandidates is the AutoRelay peer source: it offers approved friends we
+// are connected to, whose relay service will accept our reservation.
+func (f *FriendChecker) RelayCandidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	f.mu.RLock()
+	s, h := f.store, f.host
+	f.mu.RUnlock()
+
+	ch := make(chan peer.AddrInfo, num)
+	defer close(ch)
+	if s == nil || h == nil {
+		return ch
+	}
+
+	friends, err := s.GetFriends()
+	if err != nil {
+		fmt.Printf("Error listing relay candidates: %v\n", err)
+		return ch
+	}
+	for _, friend := range friends {
+		if len(ch) == num {
+			break
+		}
+		pid, err := peer.Decode(friend.PeerID)
+		if err != nil || h.Network().Connectedness(pid) != network.Connected {
+			continue
+		}
+		ch <- peer.AddrInfo{ID: pid, Addrs: h.Peerstore().Addrs(pid)}
+	}
+	return ch
 }
 
 const (
 	mdnsServiceName = "myfeed-social"
+
+	// RendezvousNamespace is the DHT namespace MyFeed nodes advertise under.
+	RendezvousNamespace = "myfeed/rendezvous/1.0.0"
+
+	maxDiscoveredPeers = 100
+
+	bootstrapConnectTimeout = 15 * time.Second
 )
 
+// Options configures the node's networking. An empty DHTProtocolPrefix joins
+// the public IPFS DHT; any other prefix (e.g. "/myfeed") forms a private
+// overlay that only nodes using the same prefix can route through.
+//
+// ConnLowWater and ConnHighWater are the connection manager watermarks; zero
+// values fall back to DefaultConnLowWater and DefaultConnHighWater.
+type Options struct {
+	BootstrapPeers    []peer.AddrInfo
+	DHTProtocolPrefix string
+	ConnLowWater      int
+	ConnHighWater     int
+}
+
+type BootstrapPeerStatus struct {
+	PeerID    string `json:"peerId"`
+	Connected bool   `json:"connected"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+type BootstrapStatus struct {
+	Protocol         string                `json:"protocol"`
+	Private          bool                  `json:"private"`
+	Peers            []BootstrapPeerStatus `json:"peers"`
+	RoutingTableSize int                   `json:"routingTableSize"`
+	LastBootstrap    time.Time             `json:"lastBootstrap,omitzero"`
+}
+
 type discoveryNotifee struct {
 	h   host.Host
 	ctx context.Context
@@ -57,8 +166,30 @@ type Node struct {
 	Host          host.Host
 	DHT           *dht.IpfsDHT
 	mdnsSvc       mdns.Service
+	discovery     *drouting.RoutingDiscovery
 	privKey       crypto.PrivKey
 	FriendChecker *FriendChecker
+
+	advertiseMu    sync.Mutex
+	advertiseUntil time.Time
+
+	dhtPrefix      protocol.ID
+	bootstrapPeers []peer.AddrInfo
+	bootstrapMu    sync.Mutex
+	bootstrapErrs  map[peer.ID]string
+	lastBootstrap  time.Time
+
+	netMu        sync.RWMutex
+	netSub       event.Subscription
+	reachability network.Reachability
+	publicAddrs  []string
+	relayAddrs   []string
+	events       *events.Bus
+
+	addrSub event.Subscription
+
+	protectMu sync.Mutex
+	protected map[peer.ID]bool
 }
 
 func loadOrGenerateKey(keyPath string) (crypto.PrivKey, error) {
@@ -92,7 +223,7 @@ func loadOrGenerateKey(keyPath string) (crypto.PrivKey, error) {
 	return priv, nil
 }
 
-func New(ctx context.Context, dataDir string) (*Node, error) {
+func New(ctx context.Context, dataDir string, opts Options) (*Node, error) {
 	keyPath := filepath.Join(dataDir, "identity.key")
 	priv, err := loadOrGenerateKey(keyPath)
 	if err != nil {
@@ -101,8 +232,38 @@ func New(ctx context.Context, dataDir string) (*Node, error) {
 
 	var kadDHT *dht.IpfsDHT
 
+	dhtPrefix := dht.DefaultPrefix
+	if opts.DHTProtocolPrefix != "" {
+		dhtPrefix = protocol.ID(opts.DHTProtocolPrefix)
+	}
+	dhtOpts := []dht.Option{
+		// Act as a DHT server until we learn we are unreachable, so nodes
+		// on a LAN or private network can still route for each other.
+		dht.Mode(dht.ModeAutoServer),
+		dht.ProtocolPrefix(dhtPrefix),
+	}
+	if len(opts.BootstrapPeers) > 0 {
+		dhtOpts = append(dhtOpts, dht.BootstrapPeers(opts.BootstrapPeers...))
+	}
+
 	friendChecker := &FriendChecker{}
 
+	lowWater, highWater := opts.ConnLowWater, opts.ConnHighWater
+	if lowWater <= 0 {
+		lowWater = DefaultConnLowWater
+	}
+	if highWater <= 0 {
+		highWater = max(DefaultConnHighWater, lowWater)
+	}
+	connMgr, err := connmgr.NewConnManager(lowWater, highWater, connmgr.WithGracePeriod(connGracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+	resourceMgr, err := newResourceManager(friendChecker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+
 	h, err := libp2p.New(
 		libp2p.Identity(priv),
 		libp2p.ListenAddrStrings(
@@ -110,15 +271,21 @@ func New(ctx context.Context, dataDir string) (*Node, error) {
 			"/ip4/0.0.0.0/udp/0/quic-v1",
 		),
 		libp2p.Security(noise.ID, noise.New),
+		libp2p.ConnectionManager(connMgr),
+		libp2p.ResourceManager(resourceMgr),
 		libp2p.DefaultTransports,
 		libp2p.NATPortMap(),
 		libp2p.EnableHolePunching(),
 		libp2p.EnableNATService(),
 		libp2p.EnableRelay(),
 		libp2p.EnableRelayService(relay.WithACL(friendChecker)),
+		libp2p.EnableAutoRelayWithPeerSource(friendChecker.RelayCandidates,
+			autorelay.WithMinCandidates(1),
+			autorelay.WithBootDelay(0),
+		),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			var err error
-			kadDHT, err = dht.New(ctx, h)
+			kadDHT, err = dht.New(ctx, h, dhtOpts...)
 			return kadDHT, err
 		}),
 	)
@@ -126,38 +293,143 @@ func New(ctx context.Context, dataDir string) (*Node, error) {
 		return nil, fmt.Errorf("failed to create host: %w", err)
 	}
 
+	friendChecker.setHost(h)
+
 	mdnsSvc := mdns.NewMdnsService(h, mdnsServiceName, &discoveryNotifee{h: h, ctx: ctx})
 
-	return &Node{
-		Host:          h,
-		DHT:           kadDHT,
-		mdnsSvc:       mdnsSvc,
-		privKey:       priv,
-		FriendChecker: friendChecker,
-	}, nil
+	n := &Node{
+		Host:           h,
+		DHT:            kadDHT,
+		mdnsSvc:        mdnsSvc,
+		discovery:      drouting.NewRoutingDiscovery(kadDHT),
+		privKey:        priv,
+		FriendChecker:  friendChecker,
+		dhtPrefix:      dhtPrefix,
+		bootstrapPeers: opts.BootstrapPeers,
+		bootstrapErrs:  make(map[peer.ID]string),
+		protected:      make(map[peer.ID]bool),
+	}
+	if err := n.watchNetwork(ctx); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// Bootstrap connects to the configured bootstrap peers and fills the DHT
+// routing table. It fails only when there were peers and none could be reached.
+func (n *Node) Bootstrap(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(n.bootstrapPeers))
+	for i, pi := range n.bootstrapPeers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connectCtx, cancel := context.WithTimeout(ctx, bootstrapConnectTimeout)
+			defer cancel()
+			errs[i] = n.Host.Connect(connectCtx, pi)
+		}()
+	}
+	wg.Wait()
+
+	connected := 0
+	n.bootstrapMu.Lock()
+	for i, pi := range n.bootstrapPeers {
+		if errs[i] != nil {
+			n.bootstrapErrs[pi.ID] = errs[i].Error()
+			continue
+		}
+		delete(n.bootstrapErrs, pi.ID)
+		connected++
+	}
+	n.lastBootstrap = time.Now()
+	n.bootstrapMu.Unlock()
+
+	if err := n.DHT.Bootstrap(ctx); err != nil {
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+	if len(n.bootstrapPeers) > 0 && connected == 0 {
+		return fmt.Errorf("could not reach any of %d bootstrap peers", len(n.bootstrapPeers))
+	}
+	return nil
+}
+
+func (n *Node) BootstrapStatus() BootstrapStatus {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	status := BootstrapStatus{
+		Protocol:         string(n.dhtPrefix + "/kad/1.0.0"),
+		Private:          n.dhtPrefix != dht.DefaultPrefix,
+		Peers:            []BootstrapPeerStatus{},
+		RoutingTableSize: n.DHT.RoutingTable().Size(),
+		LastBootstrap:    n.lastBootstrap,
+	}
+	for _, pi := range n.bootstrapPeers {
+		status.Peers = append(status.Peers, BootstrapPeerStatus{
+			PeerID:    pi.ID.String(),
+			Connected: n.IsConnected(pi.ID),
+			LastError: n.bootstrapErrs[pi.ID],
+		})
+	}
+	return status
 }
 
 func (n *Node) Close() error {
+	n.netSub.Close()
+	if n.addrSub != nil {
+		n.addrSub.Close()
+	}
 	n.mdnsSvc.Close()
 	n.DHT.Close()
 	return n.Host.Close()
 }
 
+// Advertise announces this node under the rendezvous namespace. It is cheap
+// to call often: the DHT record is only refreshed once the previous one is
+// close to expiring, and nothing is sent while the routing table is empty.
 func (n *Node) Advertise(ctx context.Context) error {
+	n.advertiseMu.Lock()
+	defer n.advertiseMu.Unlock()
+
+	if time.Now().Before(n.advertiseUntil) || n.DHT.RoutingTable().Size() == 0 {
+		return nil
+	}
+
+	ttl, err := n.discovery.Advertise(ctx, RendezvousNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to advertise: %w", err)
+	}
+	n.advertiseUntil = time.Now().Add(ttl * 7 / 8)
 	return nil
 }
 
+// DiscoverPeers returns other nodes advertising under the rendezvous namespace.
 func (n *Node) DiscoverPeers(ctx context.Context) ([]peer.AddrInfo, error) {
+	found, err := dutil.FindPeers(ctx, n.discovery, RendezvousNamespace, discovery.Limit(maxDiscoveredPeers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find peers: %w", err)
+	}
+
 	var peers []peer.AddrInfo
-	for _, p := range n.DHT.RoutingTable().ListPeers() {
-		if p == n.Host.ID() {
+	for _, p := range found {
+		if p.ID == n.Host.ID() || len(p.Addrs) == 0 {
 			continue
 		}
-		peers = append(peers, peer.AddrInfo{ID: p})
+		peers = append(peers, p)
 	}
 	return peers, nil
 }
 
+// FindPeer looks up the current addresses of a peer through the DHT.
+func (n *Node) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	info, err := n.DHT.FindPeer(ctx, p)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("failed to find peer %s: %w", p, err)
+	}
+	return info, nil
+}
+
 func (n *Node) GetListeningAddrs() []string {
 	var addrs []string
 	for _, addr := range n.Host.Addrs() {
@@ -193,10 +465,6 @@ func (n *Node) IsConnected(p peer.ID) bool {
 	return n.Host.Network().Connectedness(p) == network.Connected
 }
 
-func (n *Node) Reachability() network.Reachability {
-	return network.ReachabilityUnknown
-}
-
 func (n *Node) WaitForDHTConnection(ctx context.Context) error {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
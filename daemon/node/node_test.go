@@ -0,0 +1,98 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const testDHTPrefix = "/myfeed-test"
+
+func newTestNode(t *testing.T, ctx context.Context, bootstrap ...*Node) *Node {
+	t.Helper()
+
+	var peers []peer.AddrInfo
+	for _, b := range bootstrap {
+		peers = append(peers, peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()})
+	}
+	n, err := New(ctx, t.TempDir(), Options{BootstrapPeers: peers, DHTProtocolPrefix: testDHTPrefix})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// eventually retries check every 200ms until it succeeds or ctx is done.
+func eventually(t *testing.T, ctx context.Context, what string, check func() error) {
+	t.Helper()
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%s: %v", what, err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// TestPrivateDHTDiscovery starts three nodes on a private DHT, bootstrapped
+// off each other, and checks that one can find another's advertisement and
+// addresses through it.
+func TestPrivateDHTDiscovery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	a := newTestNode(t, ctx)
+	b := newTestNode(t, ctx, a)
+	c := newTestNode(t, ctx, a, b)
+
+	for _, n := range []*Node{a, b, c} {
+		if status := n.BootstrapStatus(); !status.Private || status.Protocol != testDHTPrefix+"/kad/1.0.0" {
+			t.Fatalf("unexpected DHT protocol %q (private %v)", status.Protocol, status.Private)
+		}
+	}
+	for _, n := range []*Node{b, c} {
+		if err := n.Bootstrap(ctx); err != nil {
+			t.Fatalf("Bootstrap: %v", err)
+		}
+	}
+
+	// Advertise is a no-op until the routing table has someone in it.
+	eventually(t, ctx, "routing table", func() error {
+		if b.DHT.RoutingTable().Size() == 0 {
+			return fmt.Errorf("routing table is empty")
+		}
+		return nil
+	})
+	if err := b.Advertise(ctx); err != nil {
+		t.Fatalf("Advertise: %v", err)
+	}
+
+	eventually(t, ctx, "discover", func() error {
+		found, err := c.DiscoverPeers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range found {
+			if p.ID == b.Host.ID() {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s not among %d discovered peers", b.Host.ID(), len(found))
+	})
+
+	info, err := c.FindPeer(ctx, b.Host.ID())
+	if err != nil {
+		t.Fatalf("FindPeer: %v", err)
+	}
+	if info.ID != b.Host.ID() || len(info.Addrs) == 0 {
+		t.Fatalf("FindPeer returned %v", info)
+	}
+}
@@ -0,0 +1,125 @@
+package protocols
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+// BlobManifestChunk is the Chunk value that requests a blob's manifest
+// instead of one of its chunks.
+const BlobManifestChunk = -1
+
+// BlobRequest asks for a blob's manifest or one chunk. Any number of
+// requests can be sent on one stream, each answered by a BlobResponse line
+// followed by Length bytes of chunk data.
+type BlobRequest struct {
+	Hash  string `json:"hash"`
+	Chunk int    `json:"chunk"`
+}
+
+type BlobResponse struct {
+	Error    string          `json:"error,omitempty"`
+	Manifest *blobs.Manifest `json:"manifest,omitempty"`
+	Length   int             `json:"length,omitempty"`
+}
+
+// canServeBlob allows a blob to be fetched if it is our avatar or attached
+// to one of our posts, and the peer would be allowed to see that.
+func (p *ProtocolHandler) canServeBlob(remote peer.ID, hash string) bool {
+	if p.isBlocked(remote) {
+		return false
+	}
+	policy := p.accessPolicy()
+	isFriend := p.store.IsFriend(remote.String())
+
+	if profile, err := p.store.GetProfile(); err == nil && profile.AvatarHash == hash {
+		if policy != store.AccessFriendsOnly || isFriend {
+			return true
+		}
+	}
+	if policy == store.AccessPublic || isFriend {
+		return p.store.IsLocalAttachment(hash)
+	}
+	return false
+}
+
+func (p *ProtocolHandler) handleBlobStream(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	reader := bufio.NewReader(s)
+	writer := bufio.NewWriter(s)
+	encoder := json.NewEncoder(writer)
+
+	respond := func(resp BlobResponse, data []byte) bool {
+		if err := encoder.Encode(resp); err != nil {
+			fmt.Printf("Error encoding blob response: %v\n", err)
+			return false
+		}
+		if _, err := writer.Write(data); err != nil {
+			fmt.Printf("Error writing blob chunk: %v\n", err)
+			return false
+		}
+		if err := writer.Flush(); err != nil {
+			fmt.Printf("Error flushing writer: %v\n", err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Error reading blob request: %v\n", err)
+			}
+			return
+		}
+
+		var req BlobRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			fmt.Printf("Error decoding blob request: %v\n", err)
+			return
+		}
+
+		hash, err := blobs.ParseHash(req.Hash)
+		if err != nil || !p.canServeBlob(remote, hash) || !p.blobs.Has(hash) {
+			respond(BlobResponse{Error: "blob not found"}, nil)
+			return
+		}
+
+		if req.Chunk == BlobManifestChunk {
+			manifest, err := p.blobs.Manifest(hash)
+			if err != nil {
+				fmt.Printf("Error building blob manifest: %v\n", err)
+				respond(BlobResponse{Error: "failed to read blob"}, nil)
+				return
+			}
+			if !respond(BlobResponse{Manifest: manifest}, nil) {
+				return
+			}
+			continue
+		}
+
+		if req.Chunk < 0 {
+			respond(BlobResponse{Error: "invalid chunk"}, nil)
+			return
+		}
+		data, err := p.blobs.ReadChunk(hash, req.Chunk)
+		if err != nil {
+			fmt.Printf("Error reading blob chunk: %v\n", err)
+			respond(BlobResponse{Error: "failed to read blob"}, nil)
+			return
+		}
+		if !respond(BlobResponse{Length: len(data)}, data) {
+			return
+		}
+	}
+}
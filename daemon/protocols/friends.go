@@ -0,0 +1,301 @@
+package protocols
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+// FriendMessage is the signed payload sent on every friend protocol. The
+// protocol ID determines what kind of message it is. A friend request made
+// from an invite carries the invite's secret so the inviter can approve it.
+type FriendMessage struct {
+	PeerID       string `json:"peerId"`
+	Timestamp    int64  `json:"timestamp"`
+	Nonce        string `json:"nonce"`
+	InviteSecret string `json:"inviteSecret,omitempty"`
+	Signature    string `json:"signature"`
+}
+
+const (
+	// friendMessageMaxAge bounds how far a friend message timestamp may drift
+	// from our clock. Nonces are remembered for twice as long so a replay
+	// can't slip in after its nonce expires.
+	friendMessageMaxAge = 10 * time.Minute
+	friendNonceTTL      = 2 * friendMessageMaxAge
+)
+
+var friendMessageKinds = map[string]string{
+	FriendRequestProtocolID:  "friend-request",
+	FriendApprovedProtocolID: "friend-approved",
+	FriendRejectedProtocolID: "friend-rejected",
+	FriendRemovedProtocolID:  "friend-removed",
+}
+
+func friendMessageSigData(kind, from, to string, msg *FriendMessage) []byte {
+	data := fmt.Sprintf("%s|%s|%s|%s|%d", kind, from, to, msg.Nonce, msg.Timestamp)
+	if msg.InviteSecret != "" {
+		data += "|invite|" + msg.InviteSecret
+	}
+	return []byte(data)
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *ProtocolHandler) sendFriendMessage(ctx context.Context, peerID peer.ID, protocolID, inviteSecret string) error {
+	kind := friendMessageKinds[protocolID]
+
+	nonce, err := newNonce()
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	msg := FriendMessage{
+		PeerID:       p.host.ID().String(),
+		Timestamp:    time.Now().Unix(),
+		Nonce:        nonce,
+		InviteSecret: inviteSecret,
+	}
+	msg.Signature, err = p.node.Sign(friendMessageSigData(kind, msg.PeerID, peerID.String(), &msg))
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w", kind, err)
+	}
+
+	stream, err := p.host.NewStream(ctx, peerID, protocol.ID(protocolID))
+	if err != nil {
+		return fmt.Errorf("failed to open %s stream: %w", kind, err)
+	}
+	defer stream.Close()
+
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// readFriendMessage decodes a friend message and checks that it was signed by
+// the peer on the other end of the stream, was addressed to us, and hasn't
+// been seen before.
+func (p *ProtocolHandler) readFriendMessage(s network.Stream) (peer.ID, *FriendMessage, error) {
+	remote := s.Conn().RemotePeer()
+	kind := friendMessageKinds[string(s.Protocol())]
+
+	var msg FriendMessage
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		return remote, nil, fmt.Errorf("failed to decode %s: %w", kind, err)
+	}
+
+	if msg.PeerID != remote.String() {
+		return remote, nil, fmt.Errorf("claimed peer %s does not match stream peer", msg.PeerID)
+	}
+	if msg.Nonce == "" || msg.Signature == "" {
+		return remote, nil, fmt.Errorf("missing nonce or signature")
+	}
+	age := time.Since(time.Unix(msg.Timestamp, 0))
+	if age > friendMessageMaxAge || age < -friendMessageMaxAge {
+		return remote, nil, fmt.Errorf("timestamp %d outside allowed window", msg.Timestamp)
+	}
+	sigData := friendMessageSigData(kind, remote.String(), p.host.ID().String(), &msg)
+	verified, err := node.VerifySignature(remote.String(), sigData, msg.Signature)
+	if err != nil {
+		return remote, nil, fmt.Errorf("failed to verify signature: %w", err)
+	}
+	if !verified {
+		return remote, nil, fmt.Errorf("invalid signature")
+	}
+	fresh, err := p.store.UseNonce(remote.String(), msg.Nonce, friendNonceTTL)
+	if err != nil {
+		return remote, nil, fmt.Errorf("failed to record nonce: %w", err)
+	}
+	if !fresh {
+		return remote, nil, fmt.Errorf("replayed nonce %s", msg.Nonce)
+	}
+	return remote, &msg, nil
+}
+
+func (p *ProtocolHandler) SendFriendRequest(ctx context.Context, peerID peer.ID) error {
+	return p.sendFriendMessage(ctx, peerID, FriendRequestProtocolID, "")
+}
+
+// SendFriendRequestWithInvite sends a friend request carrying the secret from
+// the peer's invite, which they approve without asking.
+func (p *ProtocolHandler) SendFriendRequestWithInvite(ctx context.Context, peerID peer.ID, secret string) error {
+	return p.sendFriendMessage(ctx, peerID, FriendRequestProtocolID, secret)
+}
+
+func (p *ProtocolHandler) SendFriendApproved(ctx context.Context, peerID peer.ID) error {
+	return p.sendFriendMessage(ctx, peerID, FriendApprovedProtocolID, "")
+}
+
+func (p *ProtocolHandler) SendFriendRejected(ctx context.Context, peerID peer.ID) error {
+	return p.sendFriendMessage(ctx, peerID, FriendRejectedProtocolID, "")
+}
+
+func (p *ProtocolHandler) SendFriendRemoved(ctx context.Context, peerID peer.ID) error {
+	return p.sendFriendMessage(ctx, peerID, FriendRemovedProtocolID, "")
+}
+
+func (p *ProtocolHandler) handleFriendRequestStream(s network.Stream) {
+	defer s.Close()
+
+	remote, msg, err := p.readFriendMessage(s)
+	if err != nil {
+		fmt.Printf("Rejecting friend request from %s: %v\n", remote, err)
+		return
+	}
+
+	switch p.store.GetFriendStatus(remote.String()) {
+	case store.FriendBlocked:
+		fmt.Printf("Ignoring friend request from blocked peer %s\n", remote)
+		return
+	case store.FriendApproved:
+		fmt.Printf("Ignoring friend request from existing friend %s\n", remote)
+		return
+	case store.FriendOutgoing:
+		// We already asked them, so their request completes the handshake.
+		if _, err := p.store.TransitionFriend(remote.String(), store.FriendApproved); err != nil {
+			fmt.Printf("Error approving mutual friend request: %v\n", err)
+			return
+		}
+		p.acceptFriend(remote)
+		return
+	}
+
+	if _, err := p.store.TransitionFriend(remote.String(), store.FriendIncoming); err != nil {
+		fmt.Printf("Error saving friend request: %v\n", err)
+		return
+	}
+
+	// A request carrying one of our unused invite secrets was invited, so it
+	// doesn't need approving by hand.
+	if msg.InviteSecret != "" {
+		redeemed, err := p.store.RedeemInvite(msg.InviteSecret)
+		if err != nil {
+			fmt.Printf("Error redeeming invite from %s: %v\n", remote, err)
+		}
+		if redeemed {
+			if _, err := p.store.TransitionFriend(remote.String(), store.FriendApproved); err != nil {
+				fmt.Printf("Error approving invited friend %s: %v\n", remote, err)
+				return
+			}
+			fmt.Printf("Approved friend request from %s via invite\n", remote)
+			p.acceptFriend(remote)
+			return
+		}
+		fmt.Printf("Friend request from %s carried an unknown or used invite\n", remote)
+	}
+
+	p.events.Publish(events.FriendRequest, events.Peer{PeerID: remote.String()})
+	if p.onRequest != nil {
+		p.onRequest(remote.String())
+	}
+}
+
+// acceptFriend tells a peer we approved them and runs the approval callback.
+func (p *ProtocolHandler) acceptFriend(remote peer.ID) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := p.SendFriendApproved(ctx, remote); err != nil {
+			fmt.Printf("Error sending friend approved to %s: %v\n", remote, err)
+		}
+	}()
+	p.events.Publish(events.FriendApproved, events.Peer{PeerID: remote.String()})
+	if p.onFriendApproved != nil {
+		p.onFriendApproved(remote.String())
+	}
+}
+
+func (p *ProtocolHandler) handleFriendApprovedStream(s network.Stream) {
+	defer s.Close()
+
+	remote, _, err := p.readFriendMessage(s)
+	if err != nil {
+		fmt.Printf("Rejecting friend approval from %s: %v\n", remote, err)
+		return
+	}
+
+	if p.store.GetFriendStatus(remote.String()) != store.FriendOutgoing {
+		fmt.Printf("Rejecting unsolicited friend approval from %s\n", remote)
+		return
+	}
+
+	if _, err := p.store.TransitionFriend(remote.String(), store.FriendApproved); err != nil {
+		fmt.Printf("Error saving friend approved: %v\n", err)
+		return
+	}
+
+	p.events.Publish(events.FriendApproved, events.Peer{PeerID: remote.String()})
+	if p.onFriendApproved != nil {
+		p.onFriendApproved(remote.String())
+	}
+}
+
+func (p *ProtocolHandler) handleFriendRejectedStream(s network.Stream) {
+	defer s.Close()
+
+	remote, _, err := p.readFriendMessage(s)
+	if err != nil {
+		fmt.Printf("Rejecting friend rejection from %s: %v\n", remote, err)
+		return
+	}
+
+	if p.store.GetFriendStatus(remote.String()) != store.FriendOutgoing {
+		fmt.Printf("Ignoring unsolicited friend rejection from %s\n", remote)
+		return
+	}
+
+	if _, err := p.store.TransitionFriend(remote.String(), store.FriendRejected); err != nil {
+		fmt.Printf("Error saving friend rejected: %v\n", err)
+		return
+	}
+
+	p.events.Publish(events.FriendRejected, events.Peer{PeerID: remote.String()})
+	if p.onFriendRejected != nil {
+		p.onFriendRejected(remote.String())
+	}
+}
+
+func (p *ProtocolHandler) handleFriendRemovedStream(s network.Stream) {
+	defer s.Close()
+
+	remote, _, err := p.readFriendMessage(s)
+	if err != nil {
+		fmt.Printf("Rejecting friend removal from %s: %v\n", remote, err)
+		return
+	}
+
+	// A blocked peer stays blocked regardless of what they send.
+	if p.store.GetFriendStatus(remote.String()) == store.FriendBlocked {
+		return
+	}
+
+	if err := p.store.RemoveFriend(remote.String()); err != nil {
+		if !errors.Is(err, store.ErrInvalidFriendTransition) {
+			fmt.Printf("Error removing friend %s: %v\n", remote, err)
+		}
+		return
+	}
+
+	p.events.Publish(events.FriendRemoved, events.Peer{PeerID: remote.String()})
+	if p.onFriendRemoved != nil {
+		p.onFriendRemoved(remote.String())
+	}
+}
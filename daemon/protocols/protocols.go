@@ -2,7 +2,6 @@ package protocols
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +10,9 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/node"
 	"github.com/nathanmyles/myfeed/daemon/store"
 )
 
@@ -19,30 +21,56 @@ const (
 	ProfileProtocolID        = "/socialapp/profile/1.0.0"
 	FriendRequestProtocolID  = "/socialapp/friend-request/1.0.0"
 	FriendApprovedProtocolID = "/socialapp/friend-approved/1.0.0"
+	FriendRejectedProtocolID = "/socialapp/friend-rejected/1.0.0"
+	FriendRemovedProtocolID  = "/socialapp/friend-removed/1.0.0"
+	ReactionProtocolID       = "/socialapp/reaction/1.0.0"
+	BlobProtocolID           = "/socialapp/blob/1.0.0"
 )
 
+// FeedRequest asks for changes after Seq, the author's sequence number. Older
+// clients only send Since and get changes made after that time.
 type FeedRequest struct {
 	Since time.Time `json:"since"`
+	Seq   uint64    `json:"seq,omitempty"`
 }
 
-type FriendRequestMessage struct {
-	PeerID    string `json:"peerId"`
-	Timestamp int64  `json:"timestamp"`
+// FeedItem is one line of a feed response. Post lines are plain posts;
+// tombstone and reaction lines carry only their own field, so older clients
+// decode them as an empty post and drop it when its signature fails to verify.
+type FeedItem struct {
+	store.Post
+	Tombstone *store.Tombstone       `json:"tombstone,omitempty"`
+	Reactions *store.ReactionSummary `json:"reactions,omitempty"`
 }
 
-type FriendApprovedMessage struct {
-	PeerID string `json:"peerId"`
+func (f FeedItem) MarshalJSON() ([]byte, error) {
+	switch {
+	case f.Tombstone != nil:
+		return json.Marshal(struct {
+			Tombstone *store.Tombstone `json:"tombstone"`
+		}{f.Tombstone})
+	case f.Reactions != nil:
+		return json.Marshal(struct {
+			Reactions *store.ReactionSummary `json:"reactions"`
+		}{f.Reactions})
+	}
+	return json.Marshal(f.Post)
 }
 
 type ProtocolHandler struct {
 	host             host.Host
+	node             *node.Node
 	store            *store.Store
+	blobs            *blobs.Store
 	onRequest        func(peerID string)
 	onFriendApproved func(peerID string)
+	onFriendRejected func(peerID string)
+	onFriendRemoved  func(peerID string)
+	events           *events.Bus
 }
 
-func NewProtocolHandler(h host.Host, s *store.Store) *ProtocolHandler {
-	return &ProtocolHandler{host: h, store: s}
+func NewProtocolHandler(n *node.Node, s *store.Store, b *blobs.Store) *ProtocolHandler {
+	return &ProtocolHandler{host: n.Host, node: n, store: s, blobs: b}
 }
 
 func (p *ProtocolHandler) SetFriendRequestCallback(fn func(peerID string)) {
@@ -53,51 +81,56 @@ func (p *ProtocolHandler) SetFriendApprovedCallback(fn func(peerID string)) {
 	p.onFriendApproved = fn
 }
 
+func (p *ProtocolHandler) SetFriendRejectedCallback(fn func(peerID string)) {
+	p.onFriendRejected = fn
+}
+
+func (p *ProtocolHandler) SetFriendRemovedCallback(fn func(peerID string)) {
+	p.onFriendRemoved = fn
+}
+
+func (p *ProtocolHandler) SetEventBus(bus *events.Bus) {
+	p.events = bus
+}
+
 func (p *ProtocolHandler) Register() {
 	p.host.SetStreamHandler(FeedProtocolID, p.handleFeedStream)
 	p.host.SetStreamHandler(ProfileProtocolID, p.handleProfileStream)
 	p.host.SetStreamHandler(FriendRequestProtocolID, p.handleFriendRequestStream)
 	p.host.SetStreamHandler(FriendApprovedProtocolID, p.handleFriendApprovedStream)
+	p.host.SetStreamHandler(FriendRejectedProtocolID, p.handleFriendRejectedStream)
+	p.host.SetStreamHandler(FriendRemovedProtocolID, p.handleFriendRemovedStream)
+	p.host.SetStreamHandler(ReactionProtocolID, p.handleReactionStream)
+	p.host.SetStreamHandler(BlobProtocolID, p.handleBlobStream)
 }
 
-func (p *ProtocolHandler) SendFriendRequest(ctx context.Context, peerID peer.ID) error {
-	stream, err := p.host.NewStream(ctx, peerID, FriendRequestProtocolID)
+func (p *ProtocolHandler) accessPolicy() string {
+	policy, err := p.store.GetAccessPolicy()
 	if err != nil {
-		return fmt.Errorf("failed to open friend request stream: %w", err)
-	}
-	defer stream.Close()
-
-	msg := FriendRequestMessage{
-		PeerID:    p.host.ID().String(),
-		Timestamp: time.Now().Unix(),
+		fmt.Printf("Error getting access policy, falling back to %s: %v\n", store.AccessFriendsOnly, err)
+		return store.AccessFriendsOnly
 	}
-
-	if err := json.NewEncoder(stream).Encode(msg); err != nil {
-		return fmt.Errorf("failed to encode friend request: %w", err)
-	}
-
-	return nil
+	return policy
 }
 
-func (p *ProtocolHandler) SendFriendApproved(ctx context.Context, peerID peer.ID) error {
-	stream, err := p.host.NewStream(ctx, peerID, FriendApprovedProtocolID)
-	if err != nil {
-		return fmt.Errorf("failed to open friend approved stream: %w", err)
-	}
-	defer stream.Close()
-
-	msg := FriendApprovedMessage{
-		PeerID: p.host.ID().String(),
-	}
-
-	if err := json.NewEncoder(stream).Encode(msg); err != nil {
-		return fmt.Errorf("failed to encode friend approved: %w", err)
-	}
-
-	return nil
+// isBlocked reports whether we blocked the peer. Blocked peers are refused
+// everything, whatever the access policy.
+func (p *ProtocolHandler) isBlocked(remote peer.ID) bool {
+	return p.store.GetFriendStatus(remote.String()) == store.FriendBlocked
 }
 
 func (p *ProtocolHandler) handleFeedStream(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if p.isBlocked(remote) {
+		fmt.Printf("Refusing feed request from blocked peer %s\n", remote)
+		s.Reset()
+		return
+	}
+	if p.accessPolicy() != store.AccessPublic && !p.store.IsFriend(remote.String()) {
+		fmt.Printf("Refusing feed request from non-friend %s\n", remote)
+		s.Reset()
+		return
+	}
 	defer s.Close()
 
 	var req FeedRequest
@@ -109,85 +142,90 @@ func (p *ProtocolHandler) handleFeedStream(s network.Stream) {
 		return
 	}
 
-	posts, err := p.store.GetLocalPosts(req.Since)
+	posts, tombstones, summaries, err := p.store.GetLocalFeed(store.SyncCursor{Seq: req.Seq, Time: req.Since})
 	if err != nil {
-		fmt.Printf("Error getting local posts: %v\n", err)
+		fmt.Printf("Error getting local feed: %v\n", err)
 		return
 	}
 
 	writer := bufio.NewWriter(s)
 	encoder := json.NewEncoder(writer)
-	for _, post := range posts {
-		if err := encoder.Encode(post); err != nil {
-			fmt.Printf("Error encoding post: %v\n", err)
-			return
+	write := func(line interface{}) bool {
+		if err := encoder.Encode(line); err != nil {
+			fmt.Printf("Error encoding feed item: %v\n", err)
+			return false
 		}
 		if err := writer.Flush(); err != nil {
 			fmt.Printf("Error flushing writer: %v\n", err)
-			return
+			return false
 		}
+		return true
 	}
-}
-
-func (p *ProtocolHandler) handleProfileStream(s network.Stream) {
-	defer s.Close()
 
-	profile, err := p.store.GetProfile()
-	if err != nil {
-		fmt.Printf("Error getting profile: %v\n", err)
-		return
+	for _, post := range posts {
+		if !write(post) {
+			return
+		}
 	}
-
-	encoder := json.NewEncoder(s)
-	if err := encoder.Encode(profile); err != nil {
-		fmt.Printf("Error encoding profile: %v\n", err)
+	for _, tombstone := range tombstones {
+		if !write(FeedItem{Tombstone: &tombstone}) {
+			return
+		}
+	}
+	for _, summary := range summaries {
+		if !write(FeedItem{Reactions: &summary}) {
+			return
+		}
 	}
 }
 
-func (p *ProtocolHandler) handleFriendRequestStream(s network.Stream) {
-	defer s.Close()
-
-	var msg FriendRequestMessage
-	if err := json.NewDecoder(s).Decode(&msg); err != nil {
-		fmt.Printf("Error decoding friend request: %v\n", err)
+func (p *ProtocolHandler) handleProfileStream(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if p.isBlocked(remote) {
+		fmt.Printf("Refusing profile request from blocked peer %s\n", remote)
+		s.Reset()
 		return
 	}
-
-	friend := &store.Friend{
-		PeerID:    msg.PeerID,
-		Status:    "pending",
-		CreatedAt: time.Now(),
-	}
-	if err := p.store.SaveFriend(friend); err != nil {
-		fmt.Printf("Error saving friend request: %v\n", err)
+	policy := p.accessPolicy()
+	isFriend := p.store.IsFriend(remote.String())
+	if policy == store.AccessFriendsOnly && !isFriend {
+		fmt.Printf("Refusing profile request from non-friend %s\n", remote)
+		s.Reset()
 		return
 	}
-
-	if p.onRequest != nil {
-		p.onRequest(msg.PeerID)
-	}
-}
-
-func (p *ProtocolHandler) handleFriendApprovedStream(s network.Stream) {
 	defer s.Close()
 
-	var msg FriendApprovedMessage
-	if err := json.NewDecoder(s).Decode(&msg); err != nil {
-		fmt.Printf("Error decoding friend approved: %v\n", err)
+	profile, err := p.store.GetProfile()
+	if err != nil {
+		fmt.Printf("Error getting profile: %v\n", err)
 		return
 	}
 
-	friend := &store.Friend{
-		PeerID:    msg.PeerID,
-		Status:    "approved",
-		CreatedAt: time.Now(),
-	}
-	if err := p.store.SaveFriend(friend); err != nil {
-		fmt.Printf("Error saving friend approved: %v\n", err)
-		return
+	if policy == store.AccessPublicProfileOnly && !isFriend {
+		minimal := &store.Profile{
+			PeerID:      profile.PeerID,
+			DisplayName: profile.DisplayName,
+			AvatarHash:  profile.AvatarHash,
+			Version:     profile.Version,
+		}
+		if profile.Signature != "" {
+			sig, err := p.node.Sign(minimal.SigData())
+			if err != nil {
+				fmt.Printf("Error signing profile: %v\n", err)
+				s.Reset()
+				return
+			}
+			minimal.Signature = sig
+		}
+		profile = minimal
+	} else {
+		// Friends get our current addresses, including circuit addresses
+		// through any friend relaying for us, so they can dial us back.
+		profile.Addresses = p.node.GetListeningAddrs()
 	}
 
-	if p.onFriendApproved != nil {
-		p.onFriendApproved(msg.PeerID)
+	encoder := json.NewEncoder(s)
+	if err := encoder.Encode(profile); err != nil {
+		fmt.Printf("Error encoding profile: %v\n", err)
 	}
 }
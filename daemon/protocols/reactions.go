@@ -0,0 +1,115 @@
+package protocols
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/events"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+const maxEmojiLength = 32
+
+type ReactionResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+func ValidEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= maxEmojiLength && utf8.ValidString(emoji)
+}
+
+// SendReaction delivers a signed reaction to the author of the post.
+func (p *ProtocolHandler) SendReaction(ctx context.Context, reaction *store.Reaction) error {
+	author, err := peer.Decode(reaction.PostAuthorPeerID)
+	if err != nil {
+		return fmt.Errorf("invalid post author: %w", err)
+	}
+
+	stream, err := p.host.NewStream(ctx, author, ReactionProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open reaction stream: %w", err)
+	}
+	defer stream.Close()
+
+	if err := json.NewEncoder(stream).Encode(reaction); err != nil {
+		return fmt.Errorf("failed to encode reaction: %w", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to close reaction stream: %w", err)
+	}
+
+	var resp ReactionResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode reaction response: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("reaction rejected: %s", resp.Error)
+	}
+	return nil
+}
+
+func (p *ProtocolHandler) handleReactionStream(s network.Stream) {
+	defer s.Close()
+
+	remote := s.Conn().RemotePeer()
+	respond := func(reason string) {
+		if reason != "" {
+			fmt.Printf("Rejecting reaction from %s: %s\n", remote, reason)
+		}
+		if err := json.NewEncoder(s).Encode(ReactionResponse{Error: reason}); err != nil {
+			fmt.Printf("Error encoding reaction response: %v\n", err)
+		}
+	}
+
+	if p.isBlocked(remote) {
+		respond("blocked")
+		return
+	}
+	if p.accessPolicy() != store.AccessPublic && !p.store.IsFriend(remote.String()) {
+		respond("not a friend")
+		return
+	}
+
+	var reaction store.Reaction
+	if err := json.NewDecoder(s).Decode(&reaction); err != nil {
+		fmt.Printf("Error decoding reaction: %v\n", err)
+		return
+	}
+
+	if reaction.ReactorPeerID != remote.String() {
+		respond("reactor does not match stream peer")
+		return
+	}
+	if reaction.PostAuthorPeerID != p.host.ID().String() {
+		respond("post is not ours")
+		return
+	}
+	if !ValidEmoji(reaction.Emoji) {
+		respond("invalid emoji")
+		return
+	}
+	verified, err := node.VerifySignature(remote.String(), reaction.SigData(), reaction.Signature)
+	if err != nil || !verified {
+		respond("invalid signature")
+		return
+	}
+
+	if err := p.store.SaveReaction(&reaction); err != nil {
+		if err == store.ErrPostNotFound {
+			respond("post not found")
+			return
+		}
+		fmt.Printf("Error saving reaction: %v\n", err)
+		respond("failed to save reaction")
+		return
+	}
+
+	respond("")
+
+	p.events.Publish(events.ReactionReceived, reaction)
+}
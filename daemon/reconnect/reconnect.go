@@ -0,0 +1,325 @@
+package reconnect
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+const (
+	initialBackoff = 5 * time.Second
+	maxBackoff     = 10 * time.Minute
+	checkInterval  = 2 * time.Second
+	dialTimeout    = 10 * time.Second
+	findTimeout    = 30 * time.Second
+
+	// AddressMaxAge is how long an address may go without a successful dial
+	// or being reported by its peer before it is dropped from the address book.
+	AddressMaxAge = 30 * 24 * time.Hour
+)
+
+const (
+	StateConnected    = "connected"
+	StateConnecting   = "connecting"
+	StateDisconnected = "disconnected"
+)
+
+// PeerState is the reconnection status of one approved friend.
+type PeerState struct {
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	NextRetry time.Time `json:"nextRetry,omitzero"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+type peerState struct {
+	attempts  int
+	nextRetry time.Time
+	dialing   bool
+	lastErr   string
+}
+
+// Manager keeps approved friends connected. Friends that drop off are
+// redialed with jittered exponential backoff, from their known addresses
+// first and through a DHT lookup when none of those work.
+type Manager struct {
+	node        *node.Node
+	store       *store.Store
+	onConnected func(p peer.ID)
+
+	mu    sync.Mutex
+	peers map[peer.ID]*peerState
+}
+
+func NewManager(n *node.Node, s *store.Store) *Manager {
+	return &Manager{
+		node:  n,
+		store: s,
+		peers: make(map[peer.ID]*peerState),
+	}
+}
+
+// SetConnectedCallback sets a function run after the manager dials a peer.
+func (m *Manager) SetConnectedCallback(fn func(p peer.ID)) {
+	m.onConnected = fn
+}
+
+// Start keeps approved friends connected until ctx is done. The first check
+// dials every disconnected friend at once.
+func (m *Manager) Start(ctx context.Context) {
+	if pruned, err := m.store.PruneAddresses(AddressMaxAge); err != nil {
+		fmt.Printf("Error pruning address book: %v\n", err)
+	} else if pruned > 0 {
+		fmt.Printf("Pruned %d dead addresses\n", pruned)
+	}
+
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			m.reset(conn.RemotePeer())
+		},
+		DisconnectedF: func(_ network.Network, conn network.Conn) {
+			p := conn.RemotePeer()
+			if !m.node.IsConnected(p) {
+				m.schedule(p)
+			}
+		},
+	}
+	m.node.Host.Network().Notify(notifee)
+	defer m.node.Host.Network().StopNotify(notifee)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.retryDue(ctx)
+		}
+	}
+}
+
+// States reports the reconnection status of each approved friend.
+func (m *Manager) States() map[string]PeerState {
+	friends, err := m.store.GetFriends()
+	if err != nil {
+		fmt.Printf("Error listing friends: %v\n", err)
+		return map[string]PeerState{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make(map[string]PeerState, len(friends))
+	for _, friend := range friends {
+		pid, err := peer.Decode(friend.PeerID)
+		if err != nil {
+			continue
+		}
+		state := PeerState{State: StateDisconnected}
+		if ps, ok := m.peers[pid]; ok {
+			state.Attempts = ps.attempts
+			state.NextRetry = ps.nextRetry
+			state.LastError = ps.lastErr
+			if ps.dialing {
+				state.State = StateConnecting
+			}
+		}
+		if m.node.IsConnected(pid) {
+			state = PeerState{State: StateConnected}
+		}
+		states[friend.PeerID] = state
+	}
+	return states
+}
+
+func (m *Manager) retryDue(ctx context.Context) {
+	friends, err := m.store.GetFriends()
+	if err != nil {
+		fmt.Printf("Error listing friends: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, friend := range friends {
+		pid, err := peer.Decode(friend.PeerID)
+		if err != nil || m.node.IsConnected(pid) {
+			continue
+		}
+		ps, ok := m.peers[pid]
+		if !ok {
+			ps = &peerState{}
+			m.peers[pid] = ps
+		}
+		if ps.dialing || now.Before(ps.nextRetry) {
+			continue
+		}
+		ps.dialing = true
+		go func() {
+			if err := m.dial(ctx, pid); err != nil {
+				m.failed(pid, err)
+				return
+			}
+			m.connected(pid)
+		}()
+	}
+}
+
+func (m *Manager) reset(p peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ps, ok := m.peers[p]; ok {
+		ps.attempts = 0
+		ps.nextRetry = time.Time{}
+		ps.lastErr = ""
+	}
+}
+
+// schedule queues a first retry shortly after a friend disconnects.
+func (m *Manager) schedule(p peer.ID) {
+	if !m.store.IsFriend(p.String()) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ps, ok := m.peers[p]
+	if !ok {
+		ps = &peerState{}
+		m.peers[p] = ps
+	}
+	ps.attempts = 0
+	ps.nextRetry = time.Now().Add(jitter(initialBackoff))
+}
+
+func (m *Manager) connected(p peer.ID) {
+	m.mu.Lock()
+	if ps, ok := m.peers[p]; ok {
+		ps.dialing = false
+	}
+	m.mu.Unlock()
+	m.reset(p)
+
+	fmt.Printf("Connected to known peer: %s\n", p)
+	if m.onConnected != nil {
+		m.onConnected(p)
+	}
+}
+
+func (m *Manager) failed(p peer.ID, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ps, ok := m.peers[p]
+	if !ok {
+		ps = &peerState{}
+		m.peers[p] = ps
+	}
+	ps.dialing = false
+	ps.attempts++
+	ps.nextRetry = time.Now().Add(backoff(ps.attempts))
+	ps.lastErr = err.Error()
+}
+
+// backoff doubles the delay with every failed attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	d := initialBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return jitter(min(d, maxBackoff))
+}
+
+// jitter spreads d by ±20% so friends that dropped together don't all
+// redial at the same moment.
+func jitter(d time.Duration) time.Duration {
+	return d*4/5 + rand.N(d*2/5+1)
+}
+
+// dial tries the peer's address book entries, most recently successful
+// first, then any other addresses from its profile. Friends are also looked
+// up in the DHT in case they moved networks.
+func (m *Manager) dial(ctx context.Context, p peer.ID) error {
+	var lastErr error
+	for _, addr := range m.candidateAddrs(p) {
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		err := m.node.Host.Connect(dialCtx, peer.AddrInfo{ID: p, Addrs: []multiaddr.Multiaddr{addr}})
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		m.store.RecordDialFailure(p.String(), addr.String())
+	}
+
+	if !m.store.IsFriend(p.String()) {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no known addresses for %s", p)
+		}
+		return lastErr
+	}
+	return m.findFriend(ctx, p)
+}
+
+func (m *Manager) candidateAddrs(p peer.ID) []multiaddr.Multiaddr {
+	var addrs []multiaddr.Multiaddr
+	seen := make(map[string]bool)
+	add := func(addr multiaddr.Multiaddr) {
+		if !seen[addr.String()] {
+			seen[addr.String()] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	records, err := m.store.GetPeerAddresses(p.String())
+	if err != nil {
+		fmt.Printf("Error reading address book for %s: %v\n", p, err)
+	}
+	for _, rec := range records {
+		if addr, err := multiaddr.NewMultiaddr(rec.Addr); err == nil {
+			add(addr)
+		}
+	}
+
+	profile, err := m.store.GetRemoteProfile(p.String())
+	if err != nil {
+		return addrs
+	}
+	for _, addr := range profile.Addresses {
+		if info, err := peer.AddrInfoFromString(addr); err == nil {
+			for _, a := range info.Addrs {
+				add(a)
+			}
+		}
+	}
+	return addrs
+}
+
+// findFriend looks a friend up in the DHT and adds the addresses it finds to
+// the address book before dialing them.
+func (m *Manager) findFriend(ctx context.Context, p peer.ID) error {
+	findCtx, cancel := context.WithTimeout(ctx, findTimeout)
+	defer cancel()
+
+	info, err := m.node.FindPeer(findCtx, p)
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	for _, addr := range info.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	if err := m.store.RecordAddressesSeen(p.String(), addrs); err != nil {
+		fmt.Printf("Error saving addresses for %s: %v\n", p, err)
+	}
+
+	return m.node.Host.Connect(findCtx, info)
+}
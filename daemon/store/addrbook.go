@@ -0,0 +1,207 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// maxAddressesPerPeer bounds the address book for a single peer; the least
+// recently seen addresses are dropped first.
+const maxAddressesPerPeer = 32
+
+// AddressRecord is what we know about one transport address of a peer.
+// Addresses are stored without the trailing /p2p/<peer> component.
+type AddressRecord struct {
+	PeerID      string    `json:"peerId"`
+	Addr        string    `json:"addr"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	LastFailure time.Time `json:"lastFailure,omitzero"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+}
+
+func (r *AddressRecord) lastAlive() time.Time {
+	if r.LastSuccess.After(r.LastSeen) {
+		return r.LastSuccess
+	}
+	return r.LastSeen
+}
+
+func addressKey(peerID, addr string) []byte {
+	return []byte("addr:" + peerID + ":" + addr)
+}
+
+func (s *Store) updateAddress(peerID, addr string, update func(rec *AddressRecord)) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		now := time.Now()
+		rec := AddressRecord{PeerID: peerID, Addr: addr, FirstSeen: now}
+		item, err := txn.Get(addressKey(peerID, addr))
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			err = item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		update(&rec)
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return txn.Set(addressKey(peerID, addr), data)
+	})
+}
+
+// RecordAddressesSeen notes addresses a peer told us it listens on.
+func (s *Store) RecordAddressesSeen(peerID string, addrs []string) error {
+	for _, addr := range addrs {
+		err := s.updateAddress(peerID, addr, func(rec *AddressRecord) {
+			rec.LastSeen = time.Now()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.trimAddresses(peerID)
+}
+
+// RecordDialSuccess notes that a connection to the peer was made on addr.
+func (s *Store) RecordDialSuccess(peerID, addr string) error {
+	err := s.updateAddress(peerID, addr, func(rec *AddressRecord) {
+		rec.LastSeen = time.Now()
+		rec.LastSuccess = rec.LastSeen
+		rec.Successes++
+	})
+	if err != nil {
+		return err
+	}
+	return s.trimAddresses(peerID)
+}
+
+// RecordDialFailure notes a failed dial. Unknown addresses are not added.
+func (s *Store) RecordDialFailure(peerID, addr string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(addressKey(peerID, addr))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		}
+		var rec AddressRecord
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &rec)
+		})
+		if err != nil {
+			return err
+		}
+		rec.LastFailure = time.Now()
+		rec.Failures++
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return txn.Set(addressKey(peerID, addr), data)
+	})
+}
+
+// GetPeerAddresses returns a peer's addresses, most recently successful first.
+func (s *Store) GetPeerAddresses(peerID string) ([]AddressRecord, error) {
+	var records []AddressRecord
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("addr:" + peerID + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var rec AddressRecord
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				return err
+			}
+			records = append(records, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].LastSuccess.Equal(records[j].LastSuccess) {
+			return records[i].LastSuccess.After(records[j].LastSuccess)
+		}
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+	return records, nil
+}
+
+func (s *Store) trimAddresses(peerID string) error {
+	records, err := s.GetPeerAddresses(peerID)
+	if err != nil || len(records) <= maxAddressesPerPeer {
+		return err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].lastAlive().After(records[j].lastAlive())
+	})
+	return s.db.Update(func(txn *badger.Txn) error {
+		for _, rec := range records[maxAddressesPerPeer:] {
+			if err := txn.Delete(addressKey(rec.PeerID, rec.Addr)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneAddresses deletes addresses that have neither been dialed successfully
+// nor reported by their peer for longer than maxAge.
+func (s *Store) PruneAddresses(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	var dead [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("addr:")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			var rec AddressRecord
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			})
+			if err != nil {
+				return err
+			}
+			if rec.lastAlive().Before(cutoff) {
+				dead = append(dead, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(dead) == 0 {
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range dead {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(dead), wb.Flush()
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+)
+
+// Posts are indexed by time so the feed can be paged without loading every
+// post. Index keys carry no value; the post itself lives under post:all:.
+//
+//	idx:feed:<createdAt>:<id>           every post, by creation time
+//	idx:author:<peer>:<createdAt>:<id>  every post, per author
+//	idx:local:<seq>:<id>                our posts, by sequence number for sync
+//	idx:reply:<parent>:<createdAt>:<id> replies, per parent post
+//	idx:attachment:<hash>:<id>          posts, per attached blob
+const indexVersion = "4"
+
+var ErrInvalidCursor = errors.New("invalid feed cursor")
+
+type FeedQuery struct {
+	Limit  int
+	Before string
+	After  string
+	Author string
+}
+
+// ValidPostID reports whether id is a UUID in canonical form. Post IDs are
+// embedded in index keys, so IDs from peers must not carry separators.
+func ValidPostID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
+}
+
+func indexTime(t time.Time) string {
+	nanos := t.UnixNano()
+	if t.Before(time.Unix(0, 0)) {
+		nanos = 0
+	}
+	return fmt.Sprintf("%020d", nanos)
+}
+
+// FeedCursor returns the cursor identifying post's position in the feed.
+func FeedCursor(post *Post) string {
+	return indexTime(post.CreatedAt) + ":" + post.ID
+}
+
+func validCursor(cursor string) bool {
+	nanos, id, ok := strings.Cut(cursor, ":")
+	if !ok || len(nanos) != 20 || id == "" {
+		return false
+	}
+	for _, c := range nanos {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func postIndexKeys(post *Post) [][]byte {
+	cursor := FeedCursor(post)
+	keys := [][]byte{
+		[]byte("idx:feed:" + cursor),
+		[]byte("idx:author:" + post.AuthorPeerID + ":" + cursor),
+	}
+	if post.InReplyTo != nil {
+		keys = append(keys, []byte("idx:reply:"+post.InReplyTo.PostID+":"+cursor))
+	}
+	for _, hash := range post.Attachments {
+		keys = append(keys, []byte("idx:attachment:"+hash+":"+post.ID))
+	}
+	return keys
+}
+
+func indexSeq(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func localIndexKey(post *Post) []byte {
+	return []byte("idx:local:" + indexSeq(post.Seq) + ":" + post.ID)
+}
+
+func setKeys(txn *badger.Txn, keys ...[]byte) error {
+	for _, key := range keys {
+		if err := txn.Set(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteKeys(txn *badger.Txn, keys ...[]byte) error {
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putPost writes post under post:all: (and post:local: if local) and moves
+// its index entries from any previous version. Local posts are given the next
+// sequence number, so callers must be running under updateLocal.
+func putPost(txn *badger.Txn, post *Post, local bool) error {
+	if local {
+		seq, err := nextSeq(txn)
+		if err != nil {
+			return err
+		}
+		post.Seq = seq
+	}
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+
+	previous, err := getPost(txn, "post:all:"+post.ID)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if previous != nil {
+		if err := deleteKeys(txn, postIndexKeys(previous)...); err != nil {
+			return err
+		}
+	}
+	if err := txn.Set([]byte("post:all:"+post.ID), data); err != nil {
+		return err
+	}
+	if err := setKeys(txn, postIndexKeys(post)...); err != nil {
+		return err
+	}
+
+	if !local {
+		return nil
+	}
+	previous, err = getPost(txn, "post:local:"+post.ID)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if previous != nil {
+		if err := txn.Delete(localIndexKey(previous)); err != nil {
+			return err
+		}
+	}
+	if err := txn.Set([]byte("post:local:"+post.ID), data); err != nil {
+		return err
+	}
+	return txn.Set(localIndexKey(post), nil)
+}
+
+// removePost deletes a post, its index entries and its revision history.
+func removePost(txn *badger.Txn, id string) error {
+	for _, prefix := range []string{"post:all:", "post:local:"} {
+		post, err := getPost(txn, prefix+id)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		keys := postIndexKeys(post)
+		if prefix == "post:local:" {
+			keys = [][]byte{localIndexKey(post)}
+		}
+		if err := deleteKeys(txn, keys...); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(prefix + id)); err != nil {
+			return err
+		}
+	}
+	if err := deleteRevisions(txn, id); err != nil {
+		return err
+	}
+	return deleteReactions(txn, id)
+}
+
+// buildIndexes indexes posts written before the indexes existed.
+func (s *Store) buildIndexes() error {
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("meta:index-version"))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if string(val) != indexVersion {
+				return badger.ErrKeyNotFound
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		return nil
+	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
+	if err := s.db.DropPrefix([]byte("idx:")); err != nil {
+		return err
+	}
+
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		for _, prefix := range []string{"post:all:", "post:local:"} {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte(prefix)
+			it := txn.NewIterator(opts)
+			for it.Rewind(); it.Valid(); it.Next() {
+				var post Post
+				err := it.Item().Value(func(val []byte) error {
+					return json.Unmarshal(val, &post)
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+				keys := postIndexKeys(&post)
+				if prefix == "post:local:" {
+					keys = [][]byte{localIndexKey(&post)}
+				}
+				for _, key := range keys {
+					if err := batch.Set(key, nil); err != nil {
+						it.Close()
+						return err
+					}
+				}
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Set([]byte("meta:index-version"), []byte(indexVersion)); err != nil {
+		return err
+	}
+	return batch.Flush()
+}
+
+// IsLocalAttachment reports whether hash is attached to one of our posts.
+func (s *Store) IsLocalAttachment(hash string) bool {
+	found := false
+	s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte("idx:attachment:" + hash + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := string(it.Item().Key())
+			if _, err := txn.Get([]byte("post:local:" + key[strings.LastIndex(key, ":")+1:])); err == nil {
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+func (s *Store) postsFromIndex(txn *badger.Txn, keys []string) ([]Post, error) {
+	posts := []Post{}
+	for _, key := range keys {
+		id := key[strings.LastIndex(key, ":")+1:]
+		post, err := getPost(txn, "post:all:"+id)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *post)
+	}
+	return posts, nil
+}
+
+// GetFeed returns posts newest first, optionally limited to one author and
+// to posts strictly between the Before and After cursors. When only After is
+// set, the posts immediately after it are returned.
+func (s *Store) GetFeed(q FeedQuery) ([]Post, error) {
+	if (q.Before != "" && !validCursor(q.Before)) || (q.After != "" && !validCursor(q.After)) {
+		return nil, ErrInvalidCursor
+	}
+
+	prefix := "idx:feed:"
+	if q.Author != "" {
+		prefix = "idx:author:" + q.Author + ":"
+	}
+	before := prefix + "\xff"
+	if q.Before != "" {
+		before = prefix + q.Before
+	}
+	after := ""
+	if q.After != "" {
+		after = prefix + q.After
+	}
+
+	var posts []Post
+	err := s.db.View(func(txn *badger.Txn) error {
+		var keys []string
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(prefix)
+
+		if q.After != "" && q.Before == "" {
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek([]byte(after + "\x00")); it.Valid(); it.Next() {
+				if q.Limit > 0 && len(keys) >= q.Limit {
+					break
+				}
+				keys = append(keys, string(it.Item().Key()))
+			}
+			slices.Reverse(keys)
+		} else {
+			opts.Reverse = true
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek([]byte(before)); it.Valid(); it.Next() {
+				key := string(it.Item().Key())
+				if key == before {
+					continue
+				}
+				if after != "" && key <= after {
+					break
+				}
+				if q.Limit > 0 && len(keys) >= q.Limit {
+					break
+				}
+				keys = append(keys, key)
+			}
+		}
+
+		var err error
+		posts, err = s.postsFromIndex(txn, keys)
+		return err
+	})
+	return posts, err
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// SaveInvite remembers the one-time secret of an invite we handed out until
+// it expires.
+func (s *Store) SaveInvite(secret string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		data, err := expiresAt.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry([]byte("invite:"+secret), data).WithTTL(ttl))
+	})
+}
+
+// RedeemInvite consumes an invite secret. It reports false if the secret is
+// unknown, expired or already used.
+func (s *Store) RedeemInvite(secret string) (bool, error) {
+	if secret == "" {
+		return false, nil
+	}
+	redeemed := false
+	err := s.db.Update(func(txn *badger.Txn) error {
+		key := []byte("invite:" + secret)
+		item, err := txn.Get(key)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		}
+		var expiresAt time.Time
+		err = item.Value(func(val []byte) error {
+			return expiresAt.UnmarshalBinary(val)
+		})
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+		redeemed = time.Now().Before(expiresAt)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return redeemed, nil
+}
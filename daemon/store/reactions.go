@@ -0,0 +1,230 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Reaction is a signed emoji reaction to a post. Removing a reaction is a
+// newer signed record with Removed set, so a replayed add can't undo it.
+type Reaction struct {
+	PostID           string    `json:"postId"`
+	PostAuthorPeerID string    `json:"postAuthorPeerId"`
+	ReactorPeerID    string    `json:"reactorPeerId"`
+	Emoji            string    `json:"emoji"`
+	Removed          bool      `json:"removed,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	Signature        string    `json:"signature"`
+}
+
+func (r *Reaction) SigData() []byte {
+	return []byte(fmt.Sprintf("reaction|%s|%s|%s|%s|%t|%d", r.PostAuthorPeerID, r.PostID, r.ReactorPeerID, r.Emoji, r.Removed, r.CreatedAt.UnixNano()))
+}
+
+// ReactionSummary is the per-emoji count for a post, kept by the post's
+// author and served to friends alongside the post.
+type ReactionSummary struct {
+	PostID    string         `json:"postId"`
+	Counts    map[string]int `json:"counts"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Seq       uint64         `json:"seq,omitempty"`
+}
+
+func reactionKey(r *Reaction) []byte {
+	return []byte("reaction:record:" + r.PostID + ":" + r.ReactorPeerID + ":" + r.Emoji)
+}
+
+func getReactionSummary(txn *badger.Txn, postID string) (*ReactionSummary, error) {
+	item, err := txn.Get([]byte("reaction:summary:" + postID))
+	if err != nil {
+		return nil, err
+	}
+	var summary ReactionSummary
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// recountReactions rebuilds the summary of one of our posts. It takes a
+// sequence number, so callers must be running under updateLocal.
+func (s *Store) recountReactions(txn *badger.Txn, postID string) error {
+	seq, err := nextSeq(txn)
+	if err != nil {
+		return err
+	}
+	summary := ReactionSummary{PostID: postID, Counts: map[string]int{}, UpdatedAt: time.Now(), Seq: seq}
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte("reaction:record:" + postID + ":")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		var reaction Reaction
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &reaction)
+		})
+		if err != nil {
+			return err
+		}
+		if !reaction.Removed {
+			summary.Counts[reaction.Emoji]++
+		}
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte("reaction:summary:"+postID), data)
+}
+
+// SaveReaction records a reaction if it is newer than the one we have from
+// the same reactor and emoji. Reactions to our own posts update the post's
+// summary; for other posts we only keep our own reactions and rely on the
+// author's summary for counts.
+func (s *Store) SaveReaction(reaction *Reaction) error {
+	data, err := json.Marshal(reaction)
+	if err != nil {
+		return err
+	}
+	return s.updateLocal(func(txn *badger.Txn) error {
+		key := reactionKey(reaction)
+		item, err := txn.Get(key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			var existing Reaction
+			err = item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &existing)
+			})
+			if err != nil {
+				return err
+			}
+			if !reaction.CreatedAt.After(existing.CreatedAt) {
+				return nil
+			}
+		}
+		if err := txn.Set(key, data); err != nil {
+			return err
+		}
+
+		if reaction.PostAuthorPeerID != s.localPeer {
+			return nil
+		}
+		if _, err := txn.Get([]byte("post:local:" + reaction.PostID)); err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+		return s.recountReactions(txn, reaction.PostID)
+	})
+}
+
+func localReactionSummaries(txn *badger.Txn, since SyncCursor) ([]ReactionSummary, error) {
+	var summaries []ReactionSummary
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte("reaction:summary:")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		var summary ReactionSummary
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &summary)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !since.includes(summary.Seq, summary.UpdatedAt) {
+			continue
+		}
+		if _, err := txn.Get([]byte("post:local:" + summary.PostID)); err != nil {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// SaveRemoteReactionSummary stores the counts a post's author sent us.
+func (s *Store) SaveRemoteReactionSummary(authorPeerID string, summary *ReactionSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		post, err := getPost(txn, "post:all:"+summary.PostID)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+		if post.AuthorPeerID != authorPeerID || authorPeerID == s.localPeer {
+			return fmt.Errorf("%w: reaction summary from %s for post by %s", ErrNotAuthor, authorPeerID, post.AuthorPeerID)
+		}
+		existing, err := getReactionSummary(txn, summary.PostID)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if existing != nil && !summary.UpdatedAt.After(existing.UpdatedAt) {
+			return nil
+		}
+		return txn.Set([]byte("reaction:summary:"+summary.PostID), data)
+	})
+}
+
+// GetReactions returns the reaction counts for postID and the emoji we have
+// reacted with ourselves.
+func (s *Store) GetReactions(postID string) (map[string]int, []string, error) {
+	counts := map[string]int{}
+	mine := []string{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		summary, err := getReactionSummary(txn, postID)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if summary != nil {
+			counts = summary.Counts
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("reaction:record:" + postID + ":" + s.localPeer + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var reaction Reaction
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &reaction)
+			})
+			if err != nil {
+				return err
+			}
+			if !reaction.Removed {
+				mine = append(mine, reaction.Emoji)
+			}
+		}
+		return nil
+	})
+	return counts, mine, err
+}
+
+func deleteReactions(txn *badger.Txn, postID string) error {
+	var keys [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte("reaction:record:" + postID + ":")
+	it := txn.NewIterator(opts)
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	keys = append(keys, []byte("reaction:summary:"+postID))
+	return deleteKeys(txn, keys...)
+}
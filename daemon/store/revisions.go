@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var ErrStaleRevision = errors.New("post revision is not newer than the current one")
+
+func revisionKey(postID string, revision int) []byte {
+	return []byte(fmt.Sprintf("post:revision:%s:%010d", postID, revision))
+}
+
+func getPost(txn *badger.Txn, key string) (*Post, error) {
+	item, err := txn.Get([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	var post Post
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &post)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func saveRevision(txn *badger.Txn, post *Post) error {
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	return txn.Set(revisionKey(post.ID, post.Revision), data)
+}
+
+func deleteRevisions(txn *badger.Txn, postID string) error {
+	var keys [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte("post:revision:" + postID + ":")
+	it := txn.NewIterator(opts)
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EditPost replaces a local post with a new signed revision, which must be
+// exactly one past the current revision. The current revision is kept in the
+// post's history.
+func (s *Store) EditPost(post *Post) error {
+	post.AuthorPeerID = s.localPeer
+	return s.updateLocal(func(txn *badger.Txn) error {
+		current, err := getPost(txn, "post:local:"+post.ID)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+		if post.Revision != current.Revision+1 {
+			return fmt.Errorf("%w: got %d, current is %d", ErrStaleRevision, post.Revision, current.Revision)
+		}
+		if err := saveRevision(txn, current); err != nil {
+			return err
+		}
+		return putPost(txn, post, true)
+	})
+}
+
+// GetPostRevisions returns every revision we have of a post, oldest first,
+// ending with the current one.
+func (s *Store) GetPostRevisions(postID string) ([]Post, error) {
+	var revisions []Post
+	err := s.db.View(func(txn *badger.Txn) error {
+		current, err := getPost(txn, "post:all:"+postID)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("post:revision:" + postID + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var post Post
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &post)
+			})
+			if err != nil {
+				return err
+			}
+			revisions = append(revisions, post)
+		}
+
+		revisions = append(revisions, *current)
+		return nil
+	})
+	return revisions, err
+}
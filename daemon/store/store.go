@@ -2,6 +2,11 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -13,27 +18,113 @@ type Post struct {
 	AuthorPeerID string    `json:"authorPeerId"`
 	Content      string    `json:"content"`
 	CreatedAt    time.Time `json:"createdAt"`
+	Revision     int       `json:"revision,omitempty"`
+	EditedAt     time.Time `json:"editedAt,omitzero"`
+	InReplyTo    *PostRef  `json:"inReplyTo,omitempty"`
 	Attachments  []string  `json:"attachments,omitempty"`
 	Signature    string    `json:"signature"`
+	// Seq is the author's sequence number for this revision. It orders the
+	// author's changes for sync and isn't covered by the signature.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+type PostRef struct {
+	PostID       string `json:"postId"`
+	AuthorPeerID string `json:"authorPeerId"`
+}
+
+// SigData returns the bytes a post's signature covers. Optional fields are
+// only appended when set, so plain posts keep the original
+// ID|Content|CreatedAt format and existing signatures still verify.
+func (p *Post) SigData() []byte {
+	data := fmt.Sprintf("%s|%s|%d", p.ID, p.Content, p.CreatedAt.Unix())
+	if p.Revision > 0 {
+		data += fmt.Sprintf("|%d|%d", p.Revision, p.EditedAt.Unix())
+	}
+	if p.InReplyTo != nil {
+		data += fmt.Sprintf("|reply|%s|%s", p.InReplyTo.AuthorPeerID, p.InReplyTo.PostID)
+	}
+	if len(p.Attachments) > 0 {
+		data += "|attachments|" + strings.Join(p.Attachments, ",")
+	}
+	return []byte(data)
+}
+
+// UpdatedAt is when this revision of the post was made.
+func (p *Post) UpdatedAt() time.Time {
+	if p.EditedAt.After(p.CreatedAt) {
+		return p.EditedAt
+	}
+	return p.CreatedAt
 }
 
 type Profile struct {
-	PeerID      string   `json:"peerId"`
-	DisplayName string   `json:"displayName"`
-	Bio         string   `json:"bio"`
-	AvatarHash  string   `json:"avatarHash,omitempty"`
-	Addresses   []string `json:"addresses,omitempty"`
+	PeerID      string       `json:"peerId"`
+	DisplayName string       `json:"displayName"`
+	Bio         string       `json:"bio"`
+	AvatarHash  string       `json:"avatarHash,omitempty"`
+	Addresses   []string     `json:"addresses,omitempty"`
+	Version     int          `json:"version,omitempty"`
+	Signature   string       `json:"signature,omitempty"`
+	NameHistory []NameChange `json:"nameHistory,omitempty"`
+}
+
+// SigData covers the fields a peer publishes about itself. Addresses are left
+// out because they are learned locally, and NameHistory is kept by the reader.
+func (p *Profile) SigData() []byte {
+	return []byte(fmt.Sprintf("profile|%s|%d|%s|%s|%s", p.PeerID, p.Version, p.DisplayName, p.Bio, p.AvatarHash))
 }
 
+// NameChange records a display name a peer used before switching away from it.
+type NameChange struct {
+	DisplayName string    `json:"displayName"`
+	Version     int       `json:"version"`
+	ChangedAt   time.Time `json:"changedAt"`
+}
+
+const maxNameHistory = 5
+
+var ErrStaleProfile = errors.New("profile version is older than the stored one")
+
+const (
+	FriendNone     = ""
+	FriendOutgoing = "outgoing"
+	FriendIncoming = "incoming"
+	FriendApproved = "approved"
+	FriendRejected = "rejected"
+	FriendBlocked  = "blocked"
+)
+
 type Friend struct {
 	PeerID    string    `json:"peerId"`
-	Status    string    `json:"status"` // "pending", "approved"
+	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const (
+	AccessFriendsOnly       = "friends-only"
+	AccessPublicProfileOnly = "public-profile-only"
+	AccessPublic            = "public"
+
+	DefaultAccessPolicy = AccessPublicProfileOnly
+)
+
+func ValidAccessPolicy(policy string) bool {
+	switch policy {
+	case AccessFriendsOnly, AccessPublicProfileOnly, AccessPublic:
+		return true
+	}
+	return false
 }
 
 type Store struct {
 	db        *badger.DB
 	localPeer string
+
+	// seqMu serializes transactions that take a sequence number, so changes
+	// commit in sequence order.
+	seqMu sync.Mutex
 }
 
 func New(dataDir string, localPeer string) (*Store, error) {
@@ -43,7 +134,12 @@ func New(dataDir string, localPeer string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{db: db, localPeer: localPeer}, nil
+	s := &Store{db: db, localPeer: localPeer}
+	if err := s.buildIndexes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
 }
 
 func (s *Store) Close() error {
@@ -59,16 +155,8 @@ func (s *Store) SavePost(post *Post) error {
 	}
 	post.AuthorPeerID = s.localPeer
 
-	data, err := json.Marshal(post)
-	if err != nil {
-		return err
-	}
-
-	return s.db.Update(func(txn *badger.Txn) error {
-		if err := txn.Set([]byte("post:local:"+post.ID), data); err != nil {
-			return err
-		}
-		return txn.Set([]byte("post:all:"+post.ID), data)
+	return s.updateLocal(func(txn *badger.Txn) error {
+		return putPost(txn, post, true)
 	})
 }
 
@@ -89,29 +177,53 @@ func (s *Store) GetPost(id string) (*Post, error) {
 	return &post, nil
 }
 
-func (s *Store) GetLocalPosts(since time.Time) ([]Post, error) {
+// GetLocalFeed returns our posts, tombstones and reaction summaries changed
+// since the cursor, posts in sequence order. All three are read in one
+// transaction so a sync sees a consistent snapshot.
+func (s *Store) GetLocalFeed(since SyncCursor) ([]Post, []Tombstone, []ReactionSummary, error) {
 	var posts []Post
+	var tombstones []Tombstone
+	var summaries []ReactionSummary
 	err := s.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("post:local:")
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			var post Post
-			err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &post)
-			})
-			if err != nil {
-				return err
-			}
-			if post.CreatedAt.After(since) {
-				posts = append(posts, post)
-			}
+		var err error
+		if posts, err = localPosts(txn, since); err != nil {
+			return err
 		}
-		return nil
+		if tombstones, err = localTombstones(txn, since); err != nil {
+			return err
+		}
+		summaries, err = localReactionSummaries(txn, since)
+		return err
 	})
-	return posts, err
+	return posts, tombstones, summaries, err
+}
+
+func localPosts(txn *badger.Txn, since SyncCursor) ([]Post, error) {
+	var posts []Post
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte("idx:local:")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	seek := opts.Prefix
+	if since.Seq > 0 {
+		seek = []byte("idx:local:" + indexSeq(since.Seq+1))
+	}
+	for it.Seek(seek); it.Valid(); it.Next() {
+		key := string(it.Item().Key())
+		post, err := getPost(txn, "post:local:"+key[strings.LastIndex(key, ":")+1:])
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if since.includes(post.Seq, post.UpdatedAt()) {
+			posts = append(posts, *post)
+		}
+	}
+	return posts, nil
 }
 
 func (s *Store) GetAllPosts() ([]Post, error) {
@@ -137,38 +249,42 @@ func (s *Store) GetAllPosts() ([]Post, error) {
 	return posts, err
 }
 
+// SaveRemotePost stores a post from its author. If we already have the post,
+// it is only replaced by a newer revision from the same author, and the old
+// revision is kept in the post's history.
 func (s *Store) SaveRemotePost(post *Post) error {
-	data, err := json.Marshal(post)
-	if err != nil {
-		return err
+	if !ValidPostID(post.ID) {
+		return fmt.Errorf("%w: %q", ErrInvalidPostID, post.ID)
+	}
+	if post.InReplyTo != nil && !ValidPostID(post.InReplyTo.PostID) {
+		return fmt.Errorf("%w: parent %q", ErrInvalidPostID, post.InReplyTo.PostID)
 	}
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte("post:all:"+post.ID), data)
-	})
-}
-
-func (s *Store) UpdatePostSignature(id, signature string) error {
-	return s.db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("post:all:" + id))
+		deleted, err := isTombstoned(txn, post.AuthorPeerID, post.ID)
 		if err != nil {
 			return err
 		}
-		var post Post
-		err = item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &post)
-		})
-		if err != nil {
-			return err
+		if deleted {
+			return ErrPostDeleted
 		}
-		post.Signature = signature
-		data, err := json.Marshal(post)
-		if err != nil {
+
+		existing, err := getPost(txn, "post:all:"+post.ID)
+		if err != nil && err != badger.ErrKeyNotFound {
 			return err
 		}
-		if err := txn.Set([]byte("post:local:"+id), data); err != nil {
-			return err
+		if err == nil {
+			if existing.AuthorPeerID != post.AuthorPeerID {
+				return fmt.Errorf("%w: revision from %s for post by %s", ErrNotAuthor, post.AuthorPeerID, existing.AuthorPeerID)
+			}
+			if post.Revision <= existing.Revision {
+				return nil
+			}
+			if err := saveRevision(txn, existing); err != nil {
+				return err
+			}
 		}
-		return txn.Set([]byte("post:all:"+id), data)
+
+		return putPost(txn, post, false)
 	})
 }
 
@@ -203,6 +319,36 @@ func (s *Store) SaveProfile(profile *Profile) error {
 	})
 }
 
+func (s *Store) GetAccessPolicy() (string, error) {
+	var policy string
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("settings:access-policy"))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			policy = string(val)
+			return nil
+		})
+	})
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return DefaultAccessPolicy, nil
+		}
+		return "", err
+	}
+	return policy, nil
+}
+
+func (s *Store) SetAccessPolicy(policy string) error {
+	if !ValidAccessPolicy(policy) {
+		return fmt.Errorf("invalid access policy: %q", policy)
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("settings:access-policy"), []byte(policy))
+	})
+}
+
 func (s *Store) SaveRemoteProfile(profile *Profile) error {
 	data, err := json.Marshal(profile)
 	if err != nil {
@@ -213,6 +359,10 @@ func (s *Store) SaveRemoteProfile(profile *Profile) error {
 	})
 }
 
+// SaveRemoteProfileMerge stores a profile fetched from its owner, adding any
+// addresses we already knew after the ones it reported and recording display
+// name changes. Profiles older than the stored version are rejected with
+// ErrStaleProfile.
 func (s *Store) SaveRemoteProfileMerge(profile *Profile) error {
 	return s.db.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("profile:remote:" + profile.PeerID))
@@ -228,8 +378,20 @@ func (s *Store) SaveRemoteProfileMerge(profile *Profile) error {
 			if err != nil {
 				return err
 			}
-			if len(existingProfile.Addresses) > 0 {
-				profile.Addresses = existingProfile.Addresses
+			if profile.Version < existingProfile.Version {
+				return ErrStaleProfile
+			}
+			profile.Addresses = mergeAddresses(profile.Addresses, existingProfile.Addresses)
+			profile.NameHistory = existingProfile.NameHistory
+			if existingProfile.DisplayName != "" && existingProfile.DisplayName != profile.DisplayName {
+				profile.NameHistory = append(profile.NameHistory, NameChange{
+					DisplayName: existingProfile.DisplayName,
+					Version:     existingProfile.Version,
+					ChangedAt:   time.Now(),
+				})
+				if len(profile.NameHistory) > maxNameHistory {
+					profile.NameHistory = profile.NameHistory[len(profile.NameHistory)-maxNameHistory:]
+				}
 			}
 		}
 
@@ -241,6 +403,21 @@ func (s *Store) SaveRemoteProfileMerge(profile *Profile) error {
 	})
 }
 
+const maxProfileAddresses = 20
+
+func mergeAddresses(fresh, known []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, addr := range append(fresh, known...) {
+		if seen[addr] || len(merged) == maxProfileAddresses {
+			continue
+		}
+		seen[addr] = true
+		merged = append(merged, addr)
+	}
+	return merged
+}
+
 func (s *Store) GetRemoteProfile(peerID string) (*Profile, error) {
 	var profile Profile
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -283,6 +460,14 @@ func (s *Store) GetKnownPeers() []string {
 	return peers
 }
 
+func (s *Store) IsKnownPeer(peerID string) bool {
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("profile:remote:" + peerID))
+		return err
+	})
+	return err == nil
+}
+
 func (s *Store) GetKnownPeersWithProfiles() []*Profile {
 	var profiles []*Profile
 	s.db.View(func(txn *badger.Txn) error {
@@ -306,17 +491,131 @@ func (s *Store) GetKnownPeersWithProfiles() []*Profile {
 	return profiles
 }
 
-func (s *Store) SaveFriend(friend *Friend) error {
-	data, err := json.Marshal(friend)
+var ErrInvalidFriendTransition = errors.New("invalid friend status transition")
+
+// friendTransitions lists the statuses each status may move to. FriendNone
+// means no record exists; moving to it deletes the record.
+var friendTransitions = map[string][]string{
+	FriendNone:     {FriendOutgoing, FriendIncoming, FriendBlocked},
+	FriendOutgoing: {FriendOutgoing, FriendApproved, FriendRejected, FriendNone, FriendBlocked},
+	FriendIncoming: {FriendIncoming, FriendApproved, FriendRejected, FriendNone, FriendBlocked},
+	FriendApproved: {FriendNone, FriendBlocked},
+	FriendRejected: {FriendOutgoing, FriendIncoming, FriendNone, FriendBlocked},
+	FriendBlocked:  {FriendNone},
+}
+
+func canTransitionFriend(from, to string) bool {
+	for _, allowed := range friendTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeFriend(val []byte) (Friend, error) {
+	var record struct {
+		Friend
+		Direction string `json:"direction"`
+	}
+	if err := json.Unmarshal(val, &record); err != nil {
+		return Friend{}, err
+	}
+	// Records written before the state machine used "pending" for both
+	// directions.
+	if record.Status == "pending" {
+		if record.Direction == "outgoing" {
+			record.Status = FriendOutgoing
+		} else {
+			record.Status = FriendIncoming
+		}
+	}
+	return record.Friend, nil
+}
+
+func getFriend(txn *badger.Txn, peerID string) (*Friend, error) {
+	item, err := txn.Get([]byte("friend:" + peerID))
 	if err != nil {
+		return nil, err
+	}
+	var friend Friend
+	err = item.Value(func(val []byte) error {
+		friend, err = decodeFriend(val)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &friend, nil
+}
+
+// TransitionFriend moves peerID to status to, returning the previous status.
+// It fails with ErrInvalidFriendTransition if the move isn't allowed.
+func (s *Store) TransitionFriend(peerID, to string) (string, error) {
+	from := FriendNone
+	err := s.db.Update(func(txn *badger.Txn) error {
+		now := time.Now()
+		friend, err := getFriend(txn, peerID)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == badger.ErrKeyNotFound {
+			friend = &Friend{PeerID: peerID, CreatedAt: now}
+		} else {
+			from = friend.Status
+		}
+
+		if !canTransitionFriend(from, to) {
+			return fmt.Errorf("%w: %q -> %q", ErrInvalidFriendTransition, from, to)
+		}
+
+		if to == FriendNone {
+			return txn.Delete([]byte("friend:" + peerID))
+		}
+
+		friend.Status = to
+		friend.UpdatedAt = now
+		data, err := json.Marshal(friend)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte("friend:"+peerID), data)
+	})
+	if err != nil {
+		return "", err
 	}
+	return from, nil
+}
+
+// UndoFriendTransition puts peerID back to previous if it is still in status,
+// for when the message that should have followed a transition couldn't be
+// sent. It does nothing if the status has moved on since.
+func (s *Store) UndoFriendTransition(peerID, status, previous string) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte("friend:"+friend.PeerID), data)
+		friend, err := getFriend(txn, peerID)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if friend.Status != status {
+			return nil
+		}
+		if previous == FriendNone {
+			return txn.Delete([]byte("friend:" + peerID))
+		}
+		friend.Status = previous
+		friend.UpdatedAt = time.Now()
+		data, err := json.Marshal(friend)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte("friend:"+peerID), data)
 	})
 }
 
-func (s *Store) GetFriends() ([]Friend, error) {
+func (s *Store) getFriendsWithStatus(status string) ([]Friend, error) {
 	var friends []Friend
 	err := s.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -327,12 +626,14 @@ func (s *Store) GetFriends() ([]Friend, error) {
 			item := it.Item()
 			var friend Friend
 			err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &friend)
+				var err error
+				friend, err = decodeFriend(val)
+				return err
 			})
 			if err != nil {
 				continue
 			}
-			if friend.Status == "approved" {
+			if friend.Status == status {
 				friends = append(friends, friend)
 			}
 		}
@@ -341,50 +642,176 @@ func (s *Store) GetFriends() ([]Friend, error) {
 	return friends, err
 }
 
+func (s *Store) GetFriends() ([]Friend, error) {
+	return s.getFriendsWithStatus(FriendApproved)
+}
+
 func (s *Store) GetPendingRequests() ([]Friend, error) {
-	var friends []Friend
+	return s.getFriendsWithStatus(FriendIncoming)
+}
+
+func (s *Store) GetOutgoingRequests() ([]Friend, error) {
+	return s.getFriendsWithStatus(FriendOutgoing)
+}
+
+func (s *Store) GetBlockedPeers() ([]Friend, error) {
+	return s.getFriendsWithStatus(FriendBlocked)
+}
+
+func (s *Store) GetFriend(peerID string) (*Friend, error) {
+	var friend *Friend
 	err := s.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("friend:")
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			var friend Friend
-			err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &friend)
-			})
-			if err != nil {
-				continue
-			}
-			if friend.Status == "pending" {
-				friends = append(friends, friend)
-			}
-		}
-		return nil
+		var err error
+		friend, err = getFriend(txn, peerID)
+		return err
 	})
-	return friends, err
+	if err != nil {
+		return nil, err
+	}
+	return friend, nil
+}
+
+func (s *Store) GetFriendStatus(peerID string) string {
+	friend, err := s.GetFriend(peerID)
+	if err != nil {
+		return FriendNone
+	}
+	return friend.Status
 }
 
 func (s *Store) IsFriend(peerID string) bool {
-	var friend Friend
+	return s.GetFriendStatus(peerID) == FriendApproved
+}
+
+func (s *Store) RemoveFriend(peerID string) error {
+	_, err := s.TransitionFriend(peerID, FriendNone)
+	return err
+}
+
+// UseNonce records a nonce seen from peerID and reports whether it was fresh.
+// Nonces expire after ttl, which should outlive the message timestamp window.
+func (s *Store) UseNonce(peerID, nonce string, ttl time.Duration) (bool, error) {
+	fresh := false
+	err := s.db.Update(func(txn *badger.Txn) error {
+		key := []byte("nonce:" + peerID + ":" + nonce)
+		_, err := txn.Get(key)
+		if err == nil {
+			return nil
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		fresh = true
+		return txn.SetEntry(badger.NewEntry(key, nil).WithTTL(ttl))
+	})
+	if err != nil {
+		return false, err
+	}
+	return fresh, nil
+}
+
+// SyncCursor is how far we have synced a peer's feed: the peer's sequence
+// number and timestamp of the last change we saved. Peers that don't send
+// sequence numbers are synced by time alone.
+type SyncCursor struct {
+	Seq  uint64    `json:"seq,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// includes reports whether a change with seq, made at t, comes after c.
+func (c SyncCursor) includes(seq uint64, t time.Time) bool {
+	if c.Seq > 0 {
+		return seq > c.Seq
+	}
+	return t.After(c.Time)
+}
+
+// updateLocal runs fn in a transaction that may take sequence numbers.
+func (s *Store) updateLocal(fn func(txn *badger.Txn) error) error {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+	return s.db.Update(fn)
+}
+
+// nextSeq takes the next local sequence number. Callers must be running under
+// updateLocal.
+func nextSeq(txn *badger.Txn) (uint64, error) {
+	key := []byte("meta:local-seq")
+	var seq uint64
+	item, err := txn.Get(key)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return 0, err
+	}
+	if err == nil {
+		err = item.Value(func(val []byte) error {
+			seq, err = strconv.ParseUint(string(val), 10, 64)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	seq++
+	return seq, txn.Set(key, []byte(strconv.FormatUint(seq, 10)))
+}
+
+func (s *Store) GetSyncCursor(peerID string) (SyncCursor, error) {
+	var cursor SyncCursor
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("friend:" + peerID))
+		item, err := txn.Get([]byte("sync:cursor:" + peerID))
 		if err != nil {
 			return err
 		}
 		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &friend)
+			return unmarshalSyncCursor(val, &cursor)
 		})
 	})
 	if err != nil {
-		return false
+		if err == badger.ErrKeyNotFound {
+			return SyncCursor{}, nil
+		}
+		return SyncCursor{}, err
 	}
-	return friend.Status == "approved"
+	return cursor, nil
 }
 
-func (s *Store) RemoveFriend(peerID string) error {
+func unmarshalSyncCursor(val []byte, cursor *SyncCursor) error {
+	if err := json.Unmarshal(val, cursor); err == nil {
+		return nil
+	}
+	// Cursors saved before sequence numbers are a bare binary time.
+	*cursor = SyncCursor{}
+	return cursor.Time.UnmarshalBinary(val)
+}
+
+// AdvanceSyncCursor moves a peer's cursor forward to c. It never moves back.
+func (s *Store) AdvanceSyncCursor(peerID string, c SyncCursor) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte("friend:" + peerID))
+		key := []byte("sync:cursor:" + peerID)
+		item, err := txn.Get(key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			var current SyncCursor
+			err = item.Value(func(val []byte) error {
+				return unmarshalSyncCursor(val, &current)
+			})
+			if err != nil {
+				return err
+			}
+			if c.Seq <= current.Seq && !c.Time.After(current.Time) {
+				return nil
+			}
+			c.Seq = max(c.Seq, current.Seq)
+			if current.Time.After(c.Time) {
+				c.Time = current.Time
+			}
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return txn.Set(key, data)
 	})
 }
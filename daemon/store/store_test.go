@@ -0,0 +1,62 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(t.TempDir(), "local")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestSaveRemotePostRejectsMalformedIDs checks that IDs which would break
+// out of their index key are refused before anything is written.
+func TestSaveRemotePostRejectsMalformedIDs(t *testing.T) {
+	s := newTestStore(t)
+	victim := uuid.New().String()
+
+	tests := []struct {
+		name string
+		post Post
+	}{
+		{"colon in ID", Post{ID: "x:" + victim}},
+		{"not a UUID", Post{ID: "hello"}},
+		{"uppercase UUID", Post{ID: "A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11"}},
+		{"colon in parent", Post{ID: uuid.New().String(), InReplyTo: &PostRef{PostID: victim + ":x", AuthorPeerID: "remote"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := tt.post
+			post.AuthorPeerID = "remote"
+			post.CreatedAt = time.Now()
+			if err := s.SaveRemotePost(&post); !errors.Is(err, ErrInvalidPostID) {
+				t.Fatalf("SaveRemotePost(%q) = %v, want ErrInvalidPostID", post.ID, err)
+			}
+		})
+	}
+
+	posts, err := s.GetFeed(FeedQuery{})
+	if err != nil {
+		t.Fatalf("GetFeed: %v", err)
+	}
+	if len(posts) != 0 {
+		t.Fatalf("feed has %d posts after rejected saves", len(posts))
+	}
+
+	ok := &Post{ID: uuid.New().String(), AuthorPeerID: "remote", CreatedAt: time.Now()}
+	if err := s.SaveRemotePost(ok); err != nil {
+		t.Fatalf("SaveRemotePost with a valid ID: %v", err)
+	}
+	if err := s.SaveRemoteTombstone(&Tombstone{PostID: "x:" + ok.ID, AuthorPeerID: "remote"}); !errors.Is(err, ErrInvalidPostID) {
+		t.Fatalf("SaveRemoteTombstone with a colon = %v, want ErrInvalidPostID", err)
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// maxThreadDepth stops a malicious reply cycle from looping forever.
+const maxThreadDepth = 100
+
+type ThreadNode struct {
+	Post
+	Replies []*ThreadNode `json:"replies"`
+}
+
+func getReplies(txn *badger.Txn, parentID string) ([]Post, error) {
+	var replies []Post
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte("idx:reply:" + parentID + ":")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := string(it.Item().Key())
+		post, err := getPost(txn, "post:all:"+key[strings.LastIndex(key, ":")+1:])
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, *post)
+	}
+	return replies, nil
+}
+
+// GetThread returns the conversation containing postID: the furthest
+// ancestor we have a copy of, with every reply we have beneath it, oldest
+// first at each level.
+func (s *Store) GetThread(postID string) (*ThreadNode, error) {
+	var root *ThreadNode
+	err := s.db.View(func(txn *badger.Txn) error {
+		post, err := getPost(txn, "post:all:"+postID)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+
+		for depth := 0; post.InReplyTo != nil && depth < maxThreadDepth; depth++ {
+			parent, err := getPost(txn, "post:all:"+post.InReplyTo.PostID)
+			if err == badger.ErrKeyNotFound {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			post = parent
+		}
+
+		root = &ThreadNode{Post: *post, Replies: []*ThreadNode{}}
+		seen := map[string]bool{post.ID: true}
+		level := []*ThreadNode{root}
+		for depth := 0; len(level) > 0 && depth < maxThreadDepth; depth++ {
+			var next []*ThreadNode
+			for _, node := range level {
+				replies, err := getReplies(txn, node.ID)
+				if err != nil {
+					return err
+				}
+				for _, reply := range replies {
+					if seen[reply.ID] {
+						continue
+					}
+					seen[reply.ID] = true
+					child := &ThreadNode{Post: reply, Replies: []*ThreadNode{}}
+					node.Replies = append(node.Replies, child)
+					next = append(next, child)
+				}
+			}
+			level = next
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// TombstoneRetention is how long a tombstone is kept after the post it
+// deletes. Peers that haven't synced within this window may keep the post.
+const TombstoneRetention = 90 * 24 * time.Hour
+
+var (
+	ErrPostNotFound  = errors.New("post not found")
+	ErrPostDeleted   = errors.New("post has been deleted")
+	ErrNotAuthor     = errors.New("peer is not the post's author")
+	ErrInvalidPostID = errors.New("post ID is not a UUID")
+)
+
+type Tombstone struct {
+	PostID       string    `json:"postId"`
+	AuthorPeerID string    `json:"authorPeerId"`
+	DeletedAt    time.Time `json:"deletedAt"`
+	Signature    string    `json:"signature"`
+	Seq          uint64    `json:"seq,omitempty"`
+}
+
+func (t *Tombstone) SigData() []byte {
+	return []byte(fmt.Sprintf("delete|%s|%d", t.PostID, t.DeletedAt.Unix()))
+}
+
+func (s *Store) DeletePost(tombstone *Tombstone) error {
+	tombstone.AuthorPeerID = s.localPeer
+	return s.updateLocal(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte("post:local:" + tombstone.PostID)); err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrPostNotFound
+			}
+			return err
+		}
+		if err := removePost(txn, tombstone.PostID); err != nil {
+			return err
+		}
+		seq, err := nextSeq(txn)
+		if err != nil {
+			return err
+		}
+		tombstone.Seq = seq
+		data, err := json.Marshal(tombstone)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry([]byte("tombstone:local:"+tombstone.PostID), data).WithTTL(TombstoneRetention)
+		return txn.SetEntry(entry)
+	})
+}
+
+func localTombstones(txn *badger.Txn, since SyncCursor) ([]Tombstone, error) {
+	var tombstones []Tombstone
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte("tombstone:local:")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		var tombstone Tombstone
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &tombstone)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if since.includes(tombstone.Seq, tombstone.DeletedAt) {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	return tombstones, nil
+}
+
+// SaveRemoteTombstone deletes our copy of a remote post and keeps the
+// tombstone so the post isn't re-imported by a later sync. Tombstones are
+// keyed by their author, so a peer can only suppress its own posts.
+func (s *Store) SaveRemoteTombstone(tombstone *Tombstone) error {
+	if !ValidPostID(tombstone.PostID) {
+		return fmt.Errorf("%w: %q", ErrInvalidPostID, tombstone.PostID)
+	}
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		post, err := getPost(txn, "post:all:"+tombstone.PostID)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			if post.AuthorPeerID != tombstone.AuthorPeerID {
+				return fmt.Errorf("%w: tombstone from %s for post by %s", ErrNotAuthor, tombstone.AuthorPeerID, post.AuthorPeerID)
+			}
+			if err := removePost(txn, tombstone.PostID); err != nil {
+				return err
+			}
+		}
+		entry := badger.NewEntry(remoteTombstoneKey(tombstone.AuthorPeerID, tombstone.PostID), data).WithTTL(TombstoneRetention)
+		return txn.SetEntry(entry)
+	})
+}
+
+func remoteTombstoneKey(authorPeerID, postID string) []byte {
+	return []byte("tombstone:remote:" + authorPeerID + ":" + postID)
+}
+
+// isTombstoned reports whether authorPeerID deleted postID.
+func isTombstoned(txn *badger.Txn, authorPeerID, postID string) (bool, error) {
+	_, err := txn.Get(remoteTombstoneKey(authorPeerID, postID))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/protocols"
+)
+
+// FetchBlob downloads a blob from peerID chunk by chunk, verifying each chunk
+// against the peer's manifest and the finished blob against its hash. Chunks
+// already in a partial download are verified and kept, so an interrupted
+// download resumes where it left off.
+func (s *Syncer) FetchBlob(ctx context.Context, peerID peer.ID, hash string) error {
+	hash, err := blobs.ParseHash(hash)
+	if err != nil {
+		return err
+	}
+	if s.blobs.Has(hash) {
+		return nil
+	}
+
+	s.blobMu.Lock()
+	if s.blobsInFlight[hash] {
+		s.blobMu.Unlock()
+		return nil
+	}
+	s.blobsInFlight[hash] = true
+	s.blobMu.Unlock()
+	defer func() {
+		s.blobMu.Lock()
+		delete(s.blobsInFlight, hash)
+		s.blobMu.Unlock()
+	}()
+
+	stream, err := s.host.NewStream(ctx, peerID, protocols.BlobProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open blob stream: %w", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(stream)
+	encoder := json.NewEncoder(stream)
+	request := func(chunk int) (*protocols.BlobResponse, error) {
+		if err := encoder.Encode(protocols.BlobRequest{Hash: hash, Chunk: chunk}); err != nil {
+			return nil, fmt.Errorf("failed to send blob request: %w", err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob response: %w", err)
+		}
+		var resp protocols.BlobResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode blob response: %w", err)
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return &resp, nil
+	}
+
+	resp, err := request(protocols.BlobManifestChunk)
+	if err != nil {
+		return err
+	}
+	manifest := resp.Manifest
+	if manifest == nil {
+		return fmt.Errorf("peer sent no manifest for blob %s", hash)
+	}
+	if manifest.Size > blobs.MaxSize {
+		return blobs.ErrTooLarge
+	}
+	// The chunk count must match the claimed size, or a peer could send a
+	// small size and stream chunks until our disk fills.
+	if manifest.Size < 0 || int64(len(manifest.Chunks)) != (manifest.Size+blobs.ChunkSize-1)/blobs.ChunkSize {
+		return fmt.Errorf("manifest for blob %s has %d chunks for %d bytes", hash, len(manifest.Chunks), manifest.Size)
+	}
+
+	partial, err := os.OpenFile(s.blobs.PartialPath(hash), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer partial.Close()
+
+	start, err := verifiedChunks(partial, manifest)
+	if err != nil {
+		return err
+	}
+	if err := partial.Truncate(int64(start) * blobs.ChunkSize); err != nil {
+		return err
+	}
+
+	written := int64(start) * blobs.ChunkSize
+	buf := make([]byte, blobs.ChunkSize)
+	for i := start; i < len(manifest.Chunks); i++ {
+		resp, err := request(i)
+		if err != nil {
+			return err
+		}
+		if resp.Length < 0 || resp.Length > blobs.ChunkSize {
+			return fmt.Errorf("invalid chunk length %d", resp.Length)
+		}
+		written += int64(resp.Length)
+		if written > manifest.Size || written > blobs.MaxSize {
+			return fmt.Errorf("blob %s is larger than its manifest", hash)
+		}
+		data := buf[:resp.Length]
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("failed to read blob chunk: %w", err)
+		}
+		if blobs.ChunkHash(data) != manifest.Chunks[i] {
+			return fmt.Errorf("chunk %d of blob %s failed verification", i, hash)
+		}
+		if _, err := partial.WriteAt(data, int64(i)*blobs.ChunkSize); err != nil {
+			return err
+		}
+	}
+
+	if err := partial.Close(); err != nil {
+		return err
+	}
+	return s.blobs.Commit(hash)
+}
+
+// verifiedChunks returns how many leading chunks of a partial download match
+// the manifest.
+func verifiedChunks(partial *os.File, manifest *blobs.Manifest) (int, error) {
+	buf := make([]byte, blobs.ChunkSize)
+	for i, expected := range manifest.Chunks {
+		n, err := partial.ReadAt(buf, int64(i)*blobs.ChunkSize)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 || blobs.ChunkHash(buf[:n]) != expected {
+			return i, nil
+		}
+	}
+	return len(manifest.Chunks), nil
+}
+
+// fetchBlobs downloads any of the given blobs we don't have yet.
+func (s *Syncer) fetchBlobs(peerID peer.ID, hashes []string) {
+	for _, hash := range hashes {
+		if s.blobs.Has(hash) {
+			continue
+		}
+		go func(hash string) {
+			ctx, cancel := context.WithTimeout(context.Background(), blobFetchTimeout)
+			defer cancel()
+			if err := s.FetchBlob(ctx, peerID, hash); err != nil {
+				fmt.Printf("Error fetching blob %s from %s: %v\n", hash, peerID, err)
+			}
+		}(hash)
+	}
+}
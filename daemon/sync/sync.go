@@ -4,70 +4,132 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	gosync "sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/events"
 	"github.com/nathanmyles/myfeed/daemon/node"
 	"github.com/nathanmyles/myfeed/daemon/protocols"
 	"github.com/nathanmyles/myfeed/daemon/store"
 )
 
+const blobFetchTimeout = 5 * time.Minute
+
 type Syncer struct {
-	host  host.Host
-	store *store.Store
+	host          host.Host
+	store         *store.Store
+	blobs         *blobs.Store
+	events        *events.Bus
+	blobMu        gosync.Mutex
+	blobsInFlight map[string]bool
+}
+
+func NewSyncer(h host.Host, s *store.Store, b *blobs.Store) *Syncer {
+	return &Syncer{host: h, store: s, blobs: b, blobsInFlight: make(map[string]bool)}
 }
 
-func NewSyncer(h host.Host, s *store.Store) *Syncer {
-	return &Syncer{host: h, store: s}
+func (s *Syncer) SetEventBus(bus *events.Bus) {
+	s.events = bus
 }
 
-func (s *Syncer) FetchFeed(ctx context.Context, peerID peer.ID, since time.Time) ([]store.Post, error) {
+func (s *Syncer) FetchFeed(ctx context.Context, peerID peer.ID) ([]store.Post, error) {
+	since, err := s.store.GetSyncCursor(peerID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync cursor: %w", err)
+	}
+
 	stream, err := s.host.NewStream(ctx, peerID, protocols.FeedProtocolID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open feed stream: %w", err)
 	}
 	defer stream.Close()
 
-	req := protocols.FeedRequest{Since: since}
+	req := protocols.FeedRequest{Since: since.Time, Seq: since.Seq}
 	if err := json.NewEncoder(stream).Encode(req); err != nil {
 		return nil, fmt.Errorf("failed to send feed request: %w", err)
 	}
 
+	var items []protocols.FeedItem
 	var posts []store.Post
 	reader := bufio.NewReader(stream)
 	decoder := json.NewDecoder(reader)
 	for {
-		var post store.Post
-		if err := decoder.Decode(&post); err != nil {
+		var item protocols.FeedItem
+		if err := decoder.Decode(&item); err != nil {
 			if err.Error() == "EOF" {
 				break
 			}
-			return nil, fmt.Errorf("failed to decode post: %w", err)
+			return nil, fmt.Errorf("failed to decode feed item: %w", err)
 		}
-		post.AuthorPeerID = peerID.String()
-		posts = append(posts, post)
+		// Reaction summaries are attributed to peerID when saved.
+		switch {
+		case item.Tombstone != nil:
+			item.Tombstone.AuthorPeerID = peerID.String()
+		case item.Reactions == nil:
+			item.AuthorPeerID = peerID.String()
+			posts = append(posts, item.Post)
+		}
+		items = append(items, item)
 	}
 
-	for _, post := range posts {
-		sigData := fmt.Sprintf("%s|%s|%d", post.ID, post.Content, post.CreatedAt.Unix())
-		verified, err := node.VerifySignature(post.AuthorPeerID, []byte(sigData), post.Signature)
-		if err != nil {
-			fmt.Printf("Error verifying signature for post %s: %v\n", post.ID, err)
-			continue
+	// Peers that send sequence numbers are synced in commit order; older
+	// peers send none, and their items are ordered by time.
+	sort.Slice(items, func(i, j int) bool {
+		si, ti := feedItemPosition(items[i])
+		sj, tj := feedItemPosition(items[j])
+		if si != sj {
+			return si < sj
+		}
+		return ti.Before(tj)
+	})
+
+	// Items that fail verification will never verify, so the cursor moves past
+	// them. A failed save might succeed next time, so the cursor stops there.
+	cursor := since
+	advance := true
+	newPosts := 0
+	for _, item := range items {
+		var retry bool
+		switch {
+		case item.Tombstone != nil:
+			retry = s.saveRemoteTombstone(item.Tombstone)
+		case item.Reactions != nil:
+			retry = s.saveReactionSummary(peerID, item.Reactions)
+		default:
+			var isNew bool
+			isNew, retry = s.saveRemotePost(&item.Post)
+			if isNew {
+				newPosts++
+			}
 		}
-		if !verified {
-			fmt.Printf("Invalid signature for post %s from %s\n", post.ID, post.AuthorPeerID)
+		if retry {
+			advance = false
+		}
+		if !advance {
 			continue
 		}
+		seq, t := feedItemPosition(item)
+		cursor.Seq = max(cursor.Seq, seq)
+		if t.After(cursor.Time) {
+			cursor.Time = t
+		}
+	}
 
-		if err := s.store.SaveRemotePost(&post); err != nil {
-			fmt.Printf("Error saving remote post %s: %v\n", post.ID, err)
+	if cursor.Seq > since.Seq || cursor.Time.After(since.Time) {
+		if err := s.store.AdvanceSyncCursor(peerID.String(), cursor); err != nil {
+			fmt.Printf("Error advancing sync cursor for peer %s: %v\n", peerID, err)
 		}
 	}
 
+	s.events.Publish(events.SyncCompleted, events.Sync{PeerID: peerID.String(), NewPosts: newPosts})
+
 	go func() {
 		if _, err := s.FetchProfile(ctx, peerID); err != nil {
 			fmt.Printf("Error fetching profile for peer %s: %v\n", peerID, err)
@@ -77,6 +139,91 @@ func (s *Syncer) FetchFeed(ctx context.Context, peerID peer.ID, since time.Time)
 	return posts, nil
 }
 
+// feedItemPosition returns the author's sequence number and timestamp for a
+// feed item.
+func feedItemPosition(item protocols.FeedItem) (uint64, time.Time) {
+	if item.Tombstone != nil {
+		return item.Tombstone.Seq, item.Tombstone.DeletedAt
+	}
+	if item.Reactions != nil {
+		return item.Reactions.Seq, item.Reactions.UpdatedAt
+	}
+	return item.Seq, item.UpdatedAt()
+}
+
+// saveRemotePost reports whether the post was new to us, and whether saving
+// it failed in a way worth retrying.
+func (s *Syncer) saveRemotePost(post *store.Post) (isNew, retry bool) {
+	verified, err := node.VerifySignature(post.AuthorPeerID, post.SigData(), post.Signature)
+	if err != nil {
+		fmt.Printf("Error verifying signature for post %s: %v\n", post.ID, err)
+		return false, false
+	}
+	if !verified {
+		fmt.Printf("Invalid signature for post %s from %s\n", post.ID, post.AuthorPeerID)
+		return false, false
+	}
+	_, err = s.store.GetPost(post.ID)
+	isNew = err != nil
+	if err := s.store.SaveRemotePost(post); err != nil {
+		if errors.Is(err, store.ErrPostDeleted) {
+			return false, false
+		}
+		fmt.Printf("Error saving remote post %q: %v\n", post.ID, err)
+		return false, !permanent(err)
+	}
+	if author, err := peer.Decode(post.AuthorPeerID); err == nil {
+		s.fetchBlobs(author, post.Attachments)
+	}
+	if isNew {
+		s.events.Publish(events.PostReceived, *post)
+		if post.InReplyTo != nil && post.InReplyTo.AuthorPeerID == s.host.ID().String() {
+			s.events.Publish(events.ReplyReceived, *post)
+		}
+	}
+	return isNew, false
+}
+
+// permanent reports whether a save failed in a way retrying can't fix.
+func permanent(err error) bool {
+	return errors.Is(err, store.ErrNotAuthor) || errors.Is(err, store.ErrInvalidPostID)
+}
+
+func profileChanged(before, after *store.Profile) bool {
+	return before.Version != after.Version ||
+		before.DisplayName != after.DisplayName ||
+		before.Bio != after.Bio ||
+		before.AvatarHash != after.AvatarHash
+}
+
+func (s *Syncer) saveRemoteTombstone(tombstone *store.Tombstone) bool {
+	verified, err := node.VerifySignature(tombstone.AuthorPeerID, tombstone.SigData(), tombstone.Signature)
+	if err != nil {
+		fmt.Printf("Error verifying signature for tombstone %s: %v\n", tombstone.PostID, err)
+		return false
+	}
+	if !verified {
+		fmt.Printf("Invalid signature for tombstone %s from %s\n", tombstone.PostID, tombstone.AuthorPeerID)
+		return false
+	}
+	if err := s.store.SaveRemoteTombstone(tombstone); err != nil {
+		fmt.Printf("Error saving tombstone %q: %v\n", tombstone.PostID, err)
+		return !permanent(err)
+	}
+	return false
+}
+
+func (s *Syncer) saveReactionSummary(peerID peer.ID, summary *store.ReactionSummary) bool {
+	if err := s.store.SaveRemoteReactionSummary(peerID.String(), summary); err != nil {
+		if errors.Is(err, store.ErrPostNotFound) || errors.Is(err, store.ErrNotAuthor) {
+			return false
+		}
+		fmt.Printf("Error saving reactions for post %s: %v\n", summary.PostID, err)
+		return true
+	}
+	return false
+}
+
 func (s *Syncer) FetchProfile(ctx context.Context, peerID peer.ID) (*store.Profile, error) {
 	stream, err := s.host.NewStream(ctx, peerID, protocols.ProfileProtocolID)
 	if err != nil {
@@ -89,14 +236,53 @@ func (s *Syncer) FetchProfile(ctx context.Context, peerID peer.ID) (*store.Profi
 		return nil, fmt.Errorf("failed to decode profile: %w", err)
 	}
 
+	// Profiles from daemons that predate versioning are unsigned and stay at
+	// version 0, so they can never replace a signed copy.
 	profile.PeerID = peerID.String()
+	profile.NameHistory = nil
+	profile.Addresses = ownAddresses(peerID, profile.Addresses)
+	if profile.Signature != "" {
+		verified, err := node.VerifySignature(profile.PeerID, profile.SigData(), profile.Signature)
+		if err != nil || !verified {
+			return nil, fmt.Errorf("invalid profile signature from %s", peerID)
+		}
+	} else if profile.Version != 0 {
+		return nil, fmt.Errorf("unsigned profile version %d from %s", profile.Version, peerID)
+	}
+
+	previous, err := s.store.GetRemoteProfile(profile.PeerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote profile: %w", err)
+	}
 	if err := s.store.SaveRemoteProfileMerge(&profile); err != nil {
 		return nil, fmt.Errorf("failed to save remote profile: %w", err)
 	}
+	if profileChanged(previous, &profile) {
+		if saved, err := s.store.GetRemoteProfile(profile.PeerID); err == nil {
+			s.events.Publish(events.ProfileUpdated, *saved)
+		}
+	}
+
+	if profile.AvatarHash != "" {
+		s.fetchBlobs(peerID, []string{profile.AvatarHash})
+	}
 
 	return &profile, nil
 }
 
+// ownAddresses keeps only addresses that dial the peer that reported them.
+func ownAddresses(peerID peer.ID, addrs []string) []string {
+	var own []string
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil || info.ID != peerID {
+			continue
+		}
+		own = append(own, addr)
+	}
+	return own
+}
+
 type SyncWorker struct {
 	syncer   *Syncer
 	store    *store.Store
@@ -150,7 +336,7 @@ func (w *SyncWorker) syncAllPeers(ctx context.Context) {
 			continue
 		}
 
-		_, err = w.syncer.FetchFeed(ctx, peerID, time.Time{})
+		_, err = w.syncer.FetchFeed(ctx, peerID)
 		if err != nil {
 			fmt.Printf("Error syncing feed for peer %s: %v\n", peerIDStr, err)
 		}
@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nathanmyles/myfeed/daemon/blobs"
+	"github.com/nathanmyles/myfeed/daemon/node"
+	"github.com/nathanmyles/myfeed/daemon/protocols"
+	"github.com/nathanmyles/myfeed/daemon/store"
+)
+
+type testPeer struct {
+	node  *node.Node
+	store *store.Store
+	blobs *blobs.Store
+}
+
+func newTestPeer(t *testing.T, ctx context.Context) *testPeer {
+	t.Helper()
+	dir := t.TempDir()
+
+	n, err := node.New(ctx, dir, node.Options{})
+	if err != nil {
+		t.Fatalf("node.New: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	s, err := store.New(filepath.Join(dir, "db"), n.Host.ID().String())
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	b, err := blobs.New(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("blobs.New: %v", err)
+	}
+	return &testPeer{node: n, store: s, blobs: b}
+}
+
+func (p *testPeer) post(t *testing.T, content string, createdAt time.Time) *store.Post {
+	t.Helper()
+	post := &store.Post{ID: uuid.New().String(), Content: content, CreatedAt: createdAt}
+	sig, err := p.node.Sign(post.SigData())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	post.Signature = sig
+	if err := p.store.SavePost(post); err != nil {
+		t.Fatalf("SavePost: %v", err)
+	}
+	return post
+}
+
+// TestFetchFeedAfterOlderCommit checks that a post whose timestamp is older
+// than everything already synced, but which was committed afterwards (a slow
+// concurrent write, or the author's clock stepping back), is still fetched.
+func TestFetchFeedAfterOlderCommit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	author := newTestPeer(t, ctx)
+	reader := newTestPeer(t, ctx)
+
+	if err := author.store.SetAccessPolicy(store.AccessPublic); err != nil {
+		t.Fatalf("SetAccessPolicy: %v", err)
+	}
+	protocols.NewProtocolHandler(author.node, author.store, author.blobs).Register()
+
+	syncer := NewSyncer(reader.node.Host, reader.store, reader.blobs)
+	authorInfo := peer.AddrInfo{ID: author.node.Host.ID(), Addrs: author.node.Host.Addrs()}
+	if err := reader.node.Host.Connect(ctx, authorInfo); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	now := time.Now()
+	first := author.post(t, "first", now)
+	posts, err := syncer.FetchFeed(ctx, authorInfo.ID)
+	if err != nil {
+		t.Fatalf("FetchFeed: %v", err)
+	}
+	if len(posts) != 1 || posts[0].ID != first.ID {
+		t.Fatalf("first sync returned %d posts, want %s", len(posts), first.ID)
+	}
+
+	late := author.post(t, "late", now.Add(-time.Hour))
+	posts, err = syncer.FetchFeed(ctx, authorInfo.ID)
+	if err != nil {
+		t.Fatalf("FetchFeed: %v", err)
+	}
+	if len(posts) != 1 || posts[0].ID != late.ID {
+		t.Fatalf("second sync returned %d posts, want only %s", len(posts), late.ID)
+	}
+	if _, err := reader.store.GetPost(late.ID); err != nil {
+		t.Fatalf("late post was not saved: %v", err)
+	}
+}
@@ -129,6 +129,15 @@ ipcMain.handle('read-port-file', async () => {
     return null
   }
 })
+
+ipcMain.handle('read-token-file', async () => {
+  const tokenFile = path.join(getDataDir(), 'daemon.token')
+  try {
+    return fs.readFileSync(tokenFile, 'utf-8').trim()
+  } catch {
+    return null
+  }
+})
   startDaemon()
   createWindow()
 })
@@ -22,4 +22,5 @@ contextBridge.exposeInMainWorld('ipcRenderer', {
 contextBridge.exposeInMainWorld('electron', {
   getHomeDir: () => ipcRenderer.invoke('get-home-dir'),
   readPortFile: () => ipcRenderer.invoke('read-port-file'),
+  readTokenFile: () => ipcRenderer.invoke('read-token-file'),
 })
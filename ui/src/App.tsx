@@ -12,11 +12,11 @@ function App() {
 
   useEffect(() => {
     api.connectWebSocket((event) => {
-      if (event.type === 'feed:updated') {
+      if (event.type === 'feed:updated' || event.type === 'post:received') {
         queryClient.invalidateQueries({ queryKey: ['feed'] })
-      } else if (event.type === 'peer:discovered' || event.type === 'peer:connected' || event.type === 'peer:disconnected') {
+      } else if (event.type === 'peer:connected' || event.type === 'peer:disconnected') {
         queryClient.invalidateQueries({ queryKey: ['peers'] })
-      } else if (event.type === 'friend:request' || event.type === 'friend:approved') {
+      } else if (event.type === 'friend:request' || event.type === 'friend:approved' || event.type === 'friend:rejected' || event.type === 'friend:removed') {
         queryClient.invalidateQueries({ queryKey: ['friends'] })
       }
     })
@@ -26,21 +26,36 @@ export interface Status {
   connectedPeers: number
 }
 
+export type FriendStatus = 'outgoing' | 'incoming' | 'approved' | 'rejected' | 'blocked'
+
 export interface Friend {
   peerId: string
-  status: 'pending' | 'approved'
-  direction: 'incoming' | 'outgoing'
+  status: FriendStatus
   createdAt: string
+  updatedAt: string
+}
+
+export interface FriendsResponse {
+  friends: Friend[]
+  pendingRequests: Friend[]
+  outgoingRequests: Friend[]
+  blocked: Friend[]
 }
 
+export type FriendAction = 'approve' | 'reject' | 'block' | 'unblock' | 'cancel'
+
 export interface Event {
-  type: 'peer:discovered' | 'peer:connected' | 'peer:disconnected' | 'feed:updated' | 'friend:request' | 'friend:approved'
+  seq: number
+  type: 'peer:connected' | 'peer:disconnected' | 'post:received' | 'reply:received' | 'reaction:received' | 'feed:updated' | 'profile:updated' | 'sync:completed' | 'friend:request' | 'friend:approved' | 'friend:rejected' | 'friend:removed' | 'network:reachability'
+  time: string
   data?: unknown
 }
 
 class ApiClient {
   private baseUrl: string = ''
+  private token: string = ''
   private ws: WebSocket | null = null
+  private lastSeq: number = 0
   private listeners: ((event: Event) => void)[] = []
 
   async getPort(): Promise<number> {
@@ -48,10 +63,21 @@ class ApiClient {
     if (!port) {
       throw new Error('Daemon port file not found. Is the daemon running?')
     }
+    const token = await window.electron.readTokenFile()
+    if (!token) {
+      throw new Error('Daemon token file not found. Is the daemon running?')
+    }
     this.baseUrl = `http://127.0.0.1:${port}`
+    this.token = token
     return port
   }
 
+  private request(path: string, init: RequestInit = {}): Promise<Response> {
+    const headers = new Headers(init.headers)
+    headers.set('Authorization', `Bearer ${this.token}`)
+    return fetch(`${this.baseUrl}${path}`, { ...init, headers })
+  }
+
   async ensurePort() {
     if (!this.baseUrl) {
       await this.getPort()
@@ -60,19 +86,19 @@ class ApiClient {
 
   async getStatus(): Promise<Status> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/status`)
+    const response = await this.request(`/api/status`)
     return response.json()
   }
 
   async getFeed(): Promise<Post[]> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/feed`)
+    const response = await this.request(`/api/feed`)
     return response.json()
   }
 
   async createPost(content: string): Promise<Post> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/posts`, {
+    const response = await this.request(`/api/posts`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ content })
@@ -82,31 +108,31 @@ class ApiClient {
 
   async getPeers(): Promise<Peer[]> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/peers`)
+    const response = await this.request(`/api/peers`)
     return response.json()
   }
 
   async getProfile(): Promise<Profile> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/profile`)
+    const response = await this.request(`/api/profile`)
     return response.json()
   }
 
   async getRemoteProfile(peerId: string): Promise<Profile> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/profile/${peerId}`)
+    const response = await this.request(`/api/profile/${peerId}`)
     return response.json()
   }
 
   async syncFeed(): Promise<{ syncedPeers: number }> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/sync`, { method: 'POST' })
+    const response = await this.request(`/api/sync`, { method: 'POST' })
     return response.json()
   }
 
   async updateProfile(displayName: string, bio: string): Promise<Profile> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/profile`, {
+    const response = await this.request(`/api/profile`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ displayName, bio })
@@ -116,7 +142,7 @@ class ApiClient {
 
   async connectPeer(address: string): Promise<Peer> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/connect`, {
+    const response = await this.request(`/api/connect`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ address })
@@ -128,15 +154,15 @@ class ApiClient {
     return response.json()
   }
 
-  async getFriends(): Promise<{ friends: Friend[], pendingRequests: Friend[] }> {
+  async getFriends(): Promise<FriendsResponse> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/friends`)
+    const response = await this.request(`/api/friends`)
     return response.json()
   }
 
   async sendFriendRequest(peerId: string): Promise<{ status: string }> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/friends`, {
+    const response = await this.request(`/api/friends`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ peerId })
@@ -148,21 +174,25 @@ class ApiClient {
     return response.json()
   }
 
-  async approveFriend(peerId: string): Promise<{ status: string }> {
+  async friendAction(peerId: string, action: FriendAction): Promise<{ status: string }> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/friends/${peerId}?action=approve`, {
+    const response = await this.request(`/api/friends/${peerId}?action=${action}`, {
       method: 'POST'
     })
     if (!response.ok) {
       const error = await response.json()
-      throw new Error(error.error || 'Failed to approve friend')
+      throw new Error(error.error || `Failed to ${action} friend`)
     }
     return response.json()
   }
 
+  async approveFriend(peerId: string): Promise<{ status: string }> {
+    return this.friendAction(peerId, 'approve')
+  }
+
   async removeFriend(peerId: string): Promise<{ status: string }> {
     await this.ensurePort()
-    const response = await fetch(`${this.baseUrl}/api/friends/${peerId}`, {
+    const response = await this.request(`/api/friends/${peerId}`, {
       method: 'DELETE'
     })
     if (!response.ok) {
@@ -179,7 +209,7 @@ class ApiClient {
 
     const connect = async () => {
       const port = await this.getPort()
-      const wsUrl = `ws://127.0.0.1:${port}/api/events`
+      const wsUrl = `ws://127.0.0.1:${port}/api/events?token=${encodeURIComponent(this.token)}&since=${this.lastSeq}`
       
       this.ws = new WebSocket(wsUrl)
       
@@ -190,6 +220,7 @@ class ApiClient {
       this.ws.onmessage = (event) => {
         try {
           const data = JSON.parse(event.data) as Event
+          this.lastSeq = data.seq
           this.listeners.forEach(listener => listener(data))
         } catch (err) {
           console.error('Failed to parse WebSocket message:', err)
@@ -1,5 +1,5 @@
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
-import { api } from '../api/client'
+import { api, type FriendAction } from '../api/client'
 
 export function useStatus() {
   return useQuery({
@@ -123,3 +123,14 @@ export function useRemoveFriend() {
     }
   })
 }
+
+export function useFriendAction() {
+  const queryClient = useQueryClient()
+
+  return useMutation({
+    mutationFn: ({ peerId, action }: { peerId: string, action: FriendAction }) => api.friendAction(peerId, action),
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ['friends'] })
+    }
+  })
+}
@@ -1,5 +1,6 @@
 import { useState } from 'react'
-import { usePeers, useConnectPeer, useRemoteProfile, useSendFriendRequest, useFriends } from '../api/hooks'
+import { usePeers, useConnectPeer, useRemoteProfile, useSendFriendRequest, useFriends, useFriendAction } from '../api/hooks'
+import type { FriendAction } from '../api/client'
 
 function PeerName({ peerId }: { peerId: string }) {
   const { data: profile } = useRemoteProfile(peerId)
@@ -10,8 +11,19 @@ function PeerName({ peerId }: { peerId: string }) {
   )
 }
 
-function PeerActions({ peerId, friendStatus, onFriendRequestSent }: { peerId: string, friendStatus: 'none' | 'pending' | 'friend', onFriendRequestSent: () => void }) {
+type PeerFriendStatus = 'none' | 'pending' | 'incoming' | 'friend' | 'blocked'
+
+function PeerActions({ peerId, friendStatus, onFriendRequestSent }: { peerId: string, friendStatus: PeerFriendStatus, onFriendRequestSent: () => void }) {
   const sendFriendRequest = useSendFriendRequest()
+  const friendAction = useFriendAction()
+
+  const act = async (action: FriendAction) => {
+    try {
+      await friendAction.mutateAsync({ peerId, action })
+    } catch (err) {
+      console.error(`Failed to ${action} peer:`, err)
+    }
+  }
 
   const handleAddFriend = async () => {
     try {
@@ -26,18 +38,45 @@ function PeerActions({ peerId, friendStatus, onFriendRequestSent }: { peerId: st
     return <span className="friend-status">Friend</span>
   }
 
+  if (friendStatus === 'blocked') {
+    return (
+      <div className="friend-actions">
+        <span className="friend-status blocked">Blocked</span>
+        <button onClick={() => act('unblock')} disabled={friendAction.isPending}>Unblock</button>
+      </div>
+    )
+  }
+
   if (friendStatus === 'pending') {
-    return <span className="friend-status pending">Pending</span>
+    return (
+      <div className="friend-actions">
+        <span className="friend-status pending">Pending</span>
+        <button onClick={() => act('cancel')} disabled={friendAction.isPending} className="reject-btn">Cancel</button>
+      </div>
+    )
+  }
+
+  if (friendStatus === 'incoming') {
+    return (
+      <div className="friend-actions">
+        <button onClick={() => act('approve')} disabled={friendAction.isPending} className="approve-btn">Approve</button>
+        <button onClick={() => act('reject')} disabled={friendAction.isPending} className="reject-btn">Reject</button>
+        <button onClick={() => act('block')} disabled={friendAction.isPending} className="reject-btn">Block</button>
+      </div>
+    )
   }
 
   return (
-    <button 
-      onClick={handleAddFriend}
-      disabled={sendFriendRequest.isPending}
-      className="add-friend-btn"
-    >
-      {sendFriendRequest.isPending ? '...' : 'Add Friend'}
-    </button>
+    <div className="friend-actions">
+      <button 
+        onClick={handleAddFriend}
+        disabled={sendFriendRequest.isPending}
+        className="add-friend-btn"
+      >
+        {sendFriendRequest.isPending ? '...' : 'Add Friend'}
+      </button>
+      <button onClick={() => act('block')} disabled={friendAction.isPending} className="reject-btn">Block</button>
+    </div>
   )
 }
 
@@ -49,13 +88,19 @@ export function PeersScreen() {
   const [error2, setError] = useState('')
   const [friendRequestSent, setFriendRequestSent] = useState('')
 
-  const getFriendStatus = (peerId: string): 'none' | 'pending' | 'friend' => {
+  const getFriendStatus = (peerId: string): PeerFriendStatus => {
     if (friendsData?.friends?.some(f => f.peerId === peerId)) {
       return 'friend'
     }
-    if (friendsData?.pendingRequests?.some(r => r.peerId === peerId)) {
+    if (friendsData?.blocked?.some(f => f.peerId === peerId)) {
+      return 'blocked'
+    }
+    if (friendsData?.outgoingRequests?.some(r => r.peerId === peerId)) {
       return 'pending'
     }
+    if (friendsData?.pendingRequests?.some(r => r.peerId === peerId)) {
+      return 'incoming'
+    }
     return 'none'
   }
 
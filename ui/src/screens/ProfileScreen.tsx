@@ -1,26 +1,45 @@
 import { useState, useEffect } from 'react'
-import { useProfile, useUpdateProfile, useStatus, useFriends, useSendFriendRequest, useApproveFriend, useRemoveFriend } from '../api/hooks'
+import { useProfile, useUpdateProfile, useStatus, useFriends, useSendFriendRequest, useApproveFriend, useRemoveFriend, useFriendAction } from '../api/hooks'
+import type { Friend, FriendAction } from '../api/client'
 
-function FriendRequest({ friend, onApprove, onReject }: { friend: any, onApprove: () => void, onReject: () => void }) {
+function FriendRequest({ friend, onApprove, onReject, onBlock }: { friend: Friend, onApprove: () => void, onReject: () => void, onBlock: () => void }) {
   return (
     <div className="friend-request">
       <span className="friend-peer-id">{friend.peerId}</span>
       <div className="friend-actions">
         <button onClick={onApprove} className="approve-btn">Approve</button>
         <button onClick={onReject} className="reject-btn">Reject</button>
+        <button onClick={onBlock} className="reject-btn">Block</button>
       </div>
     </div>
   )
 }
 
-function FriendList({ friends, onRemove }: { friends: any[], onRemove: (peerId: string) => void }) {
+function PeerRow({ friend, actions }: { friend: Friend, actions: { label: string, onClick: () => void }[] }) {
+  return (
+    <div className="friend-item">
+      <span className="friend-peer-id" title={friend.peerId}>{friend.peerId.slice(0, 12)}...</span>
+      <div className="friend-actions">
+        {actions.map(action => (
+          <button key={action.label} onClick={action.onClick} className="remove-btn">{action.label}</button>
+        ))}
+      </div>
+    </div>
+  )
+}
+
+function FriendList({ friends, onRemove, onBlock }: { friends: Friend[], onRemove: (peerId: string) => void, onBlock: (peerId: string) => void }) {
   return (
     <div className="friends-list">
       {friends.map(friend => (
-        <div key={friend.peerId} className="friend-item">
-          <span className="friend-peer-id" title={friend.peerId}>{friend.peerId.slice(0, 12)}...</span>
-          <button onClick={() => onRemove(friend.peerId)} className="remove-btn">Remove</button>
-        </div>
+        <PeerRow
+          key={friend.peerId}
+          friend={friend}
+          actions={[
+            { label: 'Remove', onClick: () => onRemove(friend.peerId) },
+            { label: 'Block', onClick: () => onBlock(friend.peerId) }
+          ]}
+        />
       ))}
     </div>
   )
@@ -34,6 +53,7 @@ export function ProfileScreen() {
   const sendFriendRequest = useSendFriendRequest()
   const approveFriend = useApproveFriend()
   const removeFriend = useRemoveFriend()
+  const friendAction = useFriendAction()
   const [displayName, setDisplayName] = useState('')
   const [bio, setBio] = useState('')
   const [friendPeerId, setFriendPeerId] = useState('')
@@ -65,8 +85,12 @@ export function ProfileScreen() {
     await approveFriend.mutateAsync(peerId)
   }
 
-  const handleReject = async (peerId: string) => {
-    await removeFriend.mutateAsync(peerId)
+  const handleAction = async (peerId: string, action: FriendAction) => {
+    try {
+      await friendAction.mutateAsync({ peerId, action })
+    } catch (err) {
+      console.error(`Failed to ${action} peer:`, err)
+    }
   }
 
   const handleRemove = async (peerId: string) => {
@@ -148,7 +172,21 @@ export function ProfileScreen() {
                 key={req.peerId} 
                 friend={req} 
                 onApprove={() => handleApprove(req.peerId)}
-                onReject={() => handleReject(req.peerId)}
+                onReject={() => handleAction(req.peerId, 'reject')}
+                onBlock={() => handleAction(req.peerId, 'block')}
+              />
+            ))}
+          </div>
+        )}
+
+        {friendsData?.outgoingRequests && friendsData.outgoingRequests.length > 0 && (
+          <div className="pending-requests">
+            <h4>Sent Requests</h4>
+            {friendsData.outgoingRequests.map(req => (
+              <PeerRow
+                key={req.peerId}
+                friend={req}
+                actions={[{ label: 'Cancel', onClick: () => handleAction(req.peerId, 'cancel') }]}
               />
             ))}
           </div>
@@ -157,7 +195,24 @@ export function ProfileScreen() {
         {friendsData?.friends && friendsData.friends.length > 0 && (
           <div className="friends-list-section">
             <h4>Your Friends</h4>
-            <FriendList friends={friendsData.friends} onRemove={handleRemove} />
+            <FriendList
+              friends={friendsData.friends}
+              onRemove={handleRemove}
+              onBlock={peerId => handleAction(peerId, 'block')}
+            />
+          </div>
+        )}
+
+        {friendsData?.blocked && friendsData.blocked.length > 0 && (
+          <div className="friends-list-section">
+            <h4>Blocked</h4>
+            {friendsData.blocked.map(peer => (
+              <PeerRow
+                key={peer.peerId}
+                friend={peer}
+                actions={[{ label: 'Unblock', onClick: () => handleAction(peer.peerId, 'unblock') }]}
+              />
+            ))}
           </div>
         )}
       </div>
@@ -9,6 +9,7 @@ declare global {
     electron: {
       getHomeDir(): Promise<string>
       readPortFile(): Promise<number | null>
+      readTokenFile(): Promise<string | null>
     }
   }
 }